@@ -0,0 +1,44 @@
+package apis
+
+import (
+	"mmo_game/core"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// playerProperty 保存PlayerAuth找到的玩家的请求属性
+const playerProperty = "player"
+
+// PlayerAuth 玩家鉴权中间件：只有已经绑定了在线玩家的连接发起的请求才会交给后续的业务处理
+// 找到的玩家保存在请求属性中，即使玩家随后下线，后续的业务通过GetPlayer得到的也是同一个玩家
+func PlayerAuth(next ziface.MsgHandleFunc) ziface.MsgHandleFunc {
+	return func(request ziface.IRequest) {
+		player := findPlayer(request)
+		if player == nil {
+			zlog.Warn("Request rejected, connection has no online player",
+				zlog.ConnID(request.GetConnection().GetConnID()),
+				zlog.MsgID(request.GetMsgID()))
+			return
+		}
+		request.SetProperty(playerProperty, player)
+		next(request)
+	}
+}
+
+// GetPlayer 得到PlayerAuth为当前请求找到的玩家，请求没有经过PlayerAuth鉴权时返回nil
+func GetPlayer(request ziface.IRequest) *core.Player {
+	player, err := request.GetProperty(playerProperty)
+	if err != nil {
+		return nil
+	}
+	return player.(*core.Player)
+}
+
+// findPlayer 得到发起当前请求的连接所绑定的玩家，没有绑定或者玩家已经下线时返回nil
+func findPlayer(request ziface.IRequest) *core.Player {
+	playerId, err := request.GetConnection().GetProperty("playerId")
+	if err != nil {
+		return nil
+	}
+	return core.WorldMgrObj.GetPlayerByPid(playerId.(int32))
+}
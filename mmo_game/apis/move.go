@@ -1,11 +1,10 @@
 package apis
 
 import (
-	"fmt"
 	"github.com/golang/protobuf/proto"
-	"mmo_game/core"
 	"mmo_game/pb"
 	"zinx/ziface"
+	"zinx/zlog"
 	"zinx/znet"
 )
 
@@ -20,28 +19,26 @@ func (m *MoveApi) Handle(request ziface.IRequest) {
 	protoMsg := &pb.Position{}
 	err := proto.Unmarshal(request.GetData(), protoMsg)
 	if err != nil {
-		fmt.Println("Unmarshal error:", err)
+		zlog.Warn("Unmarshal position error", zlog.ConnID(request.GetConnection().GetConnID()), zlog.Err(err))
 		return
 	}
 
-	// 2、当前的位置信息是属于哪个玩家发起的
-	playerId, err := request.GetConnection().GetProperty("playerId")
-	if err != nil {
-		fmt.Println("GetProperty playerId error:", err)
+	// 2、当前的位置信息是属于哪个玩家发起的（由PlayerAuth中间件找到）
+	player := GetPlayer(request)
+	if player == nil {
 		return
 	}
 
-	fmt.Printf("PlayerID = %d move(%f, %f, %f, %f)\n",
-		playerId,
-		protoMsg.X,
-		protoMsg.Y,
-		protoMsg.Z,
-		protoMsg.V,
-	)
-
-	// 3、根据playerId得到对应的Player对象
-	player := core.WorldMgrObj.GetPlayerByPid(playerId.(int32))
+	if zlog.Enabled(zlog.DebugLevel) {
+		zlog.Debug("Player move",
+			zlog.Any("playerID", player.PlayerID),
+			zlog.Any("x", protoMsg.X),
+			zlog.Any("y", protoMsg.Y),
+			zlog.Any("z", protoMsg.Z),
+			zlog.Any("v", protoMsg.V),
+		)
+	}
 
-	// 4、将这个位置信息广播给其他全部在线的玩家
+	// 3、将这个位置信息广播给其他全部在线的玩家
 	player.UpdatePosition(protoMsg.X, protoMsg.Y, protoMsg.Z, protoMsg.V)
 }
@@ -1,11 +1,10 @@
 package apis
 
 import (
-	"fmt"
 	"github.com/golang/protobuf/proto"
-	"mmo_game/core"
 	"mmo_game/pb"
 	"zinx/ziface"
+	"zinx/zlog"
 	"zinx/znet"
 )
 
@@ -20,20 +19,16 @@ func (wc *WorldChatApi) Handle(request ziface.IRequest) {
 	protoMsg := &pb.Talk{}
 	err := proto.Unmarshal(request.GetData(), protoMsg)
 	if err != nil {
-		fmt.Println("Unmarshal error:", err)
+		zlog.Warn("Unmarshal talk error", zlog.ConnID(request.GetConnection().GetConnID()), zlog.Err(err))
 		return
 	}
 
-	// 2、当前的聊天数据是属于哪个玩家发起的
-	playerId, err := request.GetConnection().GetProperty("playerId")
-	if err != nil {
-		fmt.Println("GetProperty playerId error:", err)
+	// 2、当前的聊天数据是属于哪个玩家发起的（由PlayerAuth中间件找到）
+	player := GetPlayer(request)
+	if player == nil {
 		return
 	}
 
-	// 3、根据playerId得到对应的Player对象
-	player := core.WorldMgrObj.GetPlayerByPid(playerId.(int32))
-
-	// 4、将这个消息广播给其他全部在线的玩家
+	// 3、将这个消息广播给其他全部在线的玩家
 	player.Talk(protoMsg.Content)
 }
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AOIConf 世界地图AOI的配置
+type AOIConf struct {
+	MinX   int `json:"min_x"`   // 区域的左边界坐标
+	MaxX   int `json:"max_x"`   // 区域的右边界坐标
+	CountX int `json:"count_x"` // X方向格子的数量
+	MinY   int `json:"min_y"`   // 区域的上边界坐标
+	MaxY   int `json:"max_y"`   // 区域的下边界坐标
+	CountY int `json:"count_y"` // Y方向格子的数量
+}
+
+// GameConf 游戏的配置，对应zinx配置文件中的app字段
+type GameConf struct {
+	AOI AOIConf `json:"aoi"` // 世界地图AOI的配置
+}
+
+// NewDefaultGameConf 创建默认的游戏配置
+func NewDefaultGameConf() *GameConf {
+	return &GameConf{
+		AOI: AOIConf{
+			MinX:   AOI_MIN_X,
+			MaxX:   AOI_MAX_X,
+			CountX: AOI_COUNT_X,
+			MinY:   AOI_MIN_Y,
+			MaxY:   AOI_MAX_Y,
+			CountY: AOI_COUNT_Y,
+		},
+	}
+}
+
+// ParseGameConf 解析zinx配置文件中的app字段，没有配置的字段保持默认值
+func ParseGameConf(data json.RawMessage) (*GameConf, error) {
+	conf := NewDefaultGameConf()
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, conf); err != nil {
+			return nil, fmt.Errorf("parse game config error: %v", err)
+		}
+	}
+	if err := conf.AOI.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Validate 校验AOI的配置，每个格子的宽度和高度都至少为1
+func (c AOIConf) Validate() error {
+	if c.CountX <= 0 || c.CountY <= 0 {
+		return fmt.Errorf("invalid aoi config: count_x %d and count_y %d must be positive", c.CountX, c.CountY)
+	}
+	if (c.MaxX-c.MinX)/c.CountX <= 0 || (c.MaxY-c.MinY)/c.CountY <= 0 {
+		return fmt.Errorf("invalid aoi config: area %d-%d x %d-%d is too small for %d x %d grids",
+			c.MinX, c.MaxX, c.MinY, c.MaxY, c.CountX, c.CountY)
+	}
+	return nil
+}
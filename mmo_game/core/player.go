@@ -1,12 +1,12 @@
 package core
 
 import (
-	"fmt"
 	"github.com/golang/protobuf/proto"
 	"math/rand"
 	"mmo_game/pb"
 	"sync"
 	"zinx/ziface"
+	"zinx/zlog"
 )
 
 type Player struct {
@@ -41,27 +41,59 @@ func NewPlayer(conn ziface.IConnection) *Player {
 	}
 }
 
-// SendMsg 发送给客户端消息，主要是将pb的protobuf数据序列化之后，再调用zinx的SendMsg方法
+// SendMsg 发送给客户端消息，主要是将pb的protobuf数据序列化之后，再调用zinx的SendBuffMsg方法
+// 使用带缓冲的发送队列，避免一个慢速的客户端阻塞广播给其他玩家
 func (p *Player) SendMsg(msgId uint32, data proto.Message) {
 	// 将proto.Message结构体序列化转换成二进制
 	msg, err := proto.Marshal(data)
 
 	if err != nil {
-		fmt.Println("Marshal error:", err)
+		zlog.Error("Marshal error", zlog.Any("playerID", p.PlayerID), zlog.MsgID(msgId), zlog.Err(err))
 		return
 	}
 
-	// 将二进制数据通过zinx框架的SendMsg发送给客户端
+	// 将二进制数据通过zinx框架的SendBuffMsg发送给客户端
 	if p.Conn == nil {
-		fmt.Println("Connection in player is nil")
+		zlog.Error("Connection in player is nil", zlog.Any("playerID", p.PlayerID))
 		return
 	}
-	if err := p.Conn.SendMsg(msgId, msg); err != nil {
-		fmt.Println("SendMsg error:", err)
+	if err := p.Conn.SendBuffMsg(msgId, msg); err != nil {
+		zlog.Debug("SendMsg error", zlog.Any("playerID", p.PlayerID), zlog.MsgID(msgId), zlog.Err(err))
 		return
 	}
 }
 
+// SendMsgToPlayers 将同一个消息发送给多个玩家，proto数据只序列化一次
+// 设置了连接管理模块时通过Multicast发送，所有玩家共享同一份封包之后的数据
+func SendMsgToPlayers(players []*Player, msgId uint32, data proto.Message) {
+	msg, err := proto.Marshal(data)
+	if err != nil {
+		zlog.Error("Marshal error", zlog.MsgID(msgId), zlog.Err(err))
+		return
+	}
+
+	connMgr := WorldMgrObj.connMgr
+	connIds := make([]uint32, 0, len(players))
+	for _, player := range players {
+		if player == nil || player.Conn == nil {
+			continue
+		}
+		if connMgr == nil {
+			if err := player.Conn.SendBuffMsg(msgId, msg); err != nil {
+				zlog.Debug("SendMsg error", zlog.Any("playerID", player.PlayerID), zlog.MsgID(msgId), zlog.Err(err))
+			}
+			continue
+		}
+		connIds = append(connIds, player.Conn.GetConnID())
+	}
+	if connMgr == nil {
+		return
+	}
+	for connId, err := range connMgr.Multicast(connIds, msgId, msg) {
+		zlog.Debug("Multicast error", zlog.ConnID(connId), zlog.MsgID(msgId), zlog.Err(err))
+	}
+}
+
 // SyncPid 将PlayerID同步给客户端
 func (p *Player) SyncPid() {
 	// 组建MsgID为1的proto数据
@@ -108,10 +140,7 @@ func (p *Player) Talk(content string) {
 	players := WorldMgrObj.GetAllPlayers()
 
 	// 3、向所有的玩家（包括自己）发送MsgID为200的消息
-	for _, player := range players {
-		// 分别给对应的客户端发送消息
-		player.SendMsg(200, protoMsg)
-	}
+	SendMsgToPlayers(players, 200, protoMsg)
 }
 
 // SyncSurrounding 同步玩家上线的位置消息
@@ -134,9 +163,7 @@ func (p *Player) SyncSurrounding() {
 		},
 	}
 	// 2.2、全部周围的玩家都向各自的客户端发送200消息
-	for _, player := range players {
-		player.SendMsg(200, protoMsg)
-	}
+	SendMsgToPlayers(players, 200, protoMsg)
 
 	// 3、将周围的全部玩家的位置信息发送给当前的玩家（让自己看到其他玩家）
 	// 3.1、组建MsgID为202的proto数据
@@ -189,10 +216,8 @@ func (p *Player) UpdatePosition(x, y, z, v float32) {
 	// 获取当前玩家的周围玩家
 	players := p.GetSurroundPlayers()
 
-	// 依次给每个玩家对应的客户端发送当前玩家位置更新的消息
-	for _, player := range players {
-		player.SendMsg(200, protoMsg)
-	}
+	// 给每个玩家对应的客户端发送当前玩家位置更新的消息
+	SendMsgToPlayers(players, 200, protoMsg)
 }
 
 // Offline 玩家下线业务
@@ -205,16 +230,14 @@ func (p *Player) Offline() {
 		Pid: p.PlayerID,
 	}
 
-	for _, player := range players {
-		player.SendMsg(201, protoMsg)
-	}
+	SendMsgToPlayers(players, 201, protoMsg)
 
 	WorldMgrObj.RemovePlayerByPid(p.PlayerID)
 }
 
 // GetSurroundPlayers 获取当前玩家的周围玩家（AOI九宫格内的玩家）
 func (p *Player) GetSurroundPlayers() []*Player {
-	playerIds := WorldMgrObj.AOIMgr.GetPidByPos(p.X, p.Z)
+	playerIds := WorldMgrObj.GetAOIMgr().GetPidByPos(p.X, p.Z)
 	players := make([]*Player, 0, len(playerIds))
 	for _, playerId := range playerIds {
 		players = append(players, WorldMgrObj.GetPlayerByPid(int32(playerId)))
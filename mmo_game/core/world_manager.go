@@ -1,12 +1,16 @@
 package core
 
-import "sync"
+import (
+	"sync"
+	"zinx/ziface"
+)
 
 // WorldManager 当前游戏的实际管理模块
 type WorldManager struct {
-	AOIMgr  *AOIManager       // 当前世界地图AOI的管理模块
-	Players map[int32]*Player // 当前全部在线的玩家集合
-	Lock    sync.RWMutex      // 保护玩家集合的锁
+	AOIMgr  *AOIManager         // 当前世界地图AOI的管理模块，重新配置时会被替换，需要通过GetAOIMgr获取
+	Players map[int32]*Player   // 当前全部在线的玩家集合
+	Lock    sync.RWMutex        // 保护AOI管理模块和玩家集合的锁
+	connMgr ziface.IConnManager // 玩家连接所属的连接管理模块，用于给多个玩家广播消息，在启动服务之前设置
 }
 
 // WorldMgrObj 提供一个对外的全局的世界管理模块句柄
@@ -25,23 +29,23 @@ func init() {
 // AddPlayer 添加一个玩家
 func (wm *WorldManager) AddPlayer(player *Player) {
 	wm.Lock.Lock()
-	wm.Players[player.PlayerID] = player
-	wm.Lock.Unlock()
+	defer wm.Lock.Unlock()
 
+	wm.Players[player.PlayerID] = player
 	// 将player添加到AOIManager中
 	wm.AOIMgr.AddPidToGridByPos(int(player.PlayerID), player.X, player.Z)
 }
 
 // RemovePlayerByPid 删除一个玩家
 func (wm *WorldManager) RemovePlayerByPid(playerId int32) {
+	wm.Lock.Lock()
+	defer wm.Lock.Unlock()
+
 	if player, ok := wm.Players[playerId]; ok {
 		// 将player从AOIManager中删除
 		wm.AOIMgr.RemovePidFromGridByPos(int(playerId), player.X, player.Z)
 	}
-	wm.Lock.Lock()
 	delete(wm.Players, playerId)
-	wm.Lock.Unlock()
-
 }
 
 // GetPlayerByPid 通过玩家ID查询Player对象
@@ -63,3 +67,33 @@ func (wm *WorldManager) GetAllPlayers() (players []*Player) {
 	}
 	return
 }
+
+// SetConnManager 设置玩家连接所属的连接管理模块，设置之后给多个玩家发送消息时只序列化和封包一次
+func (wm *WorldManager) SetConnManager(connMgr ziface.IConnManager) {
+	wm.connMgr = connMgr
+}
+
+// GetAOIMgr 获取当前世界地图AOI的管理模块
+func (wm *WorldManager) GetAOIMgr() *AOIManager {
+	wm.Lock.RLock()
+	defer wm.Lock.RUnlock()
+
+	return wm.AOIMgr
+}
+
+// ResetAOI 按照新的配置重建世界地图AOI，并将全部在线玩家按照当前坐标重新加入到格子中
+func (wm *WorldManager) ResetAOI(conf AOIConf) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	wm.Lock.Lock()
+	defer wm.Lock.Unlock()
+
+	aoiMgr := NewAOIManager(conf.MinX, conf.MaxX, conf.CountX, conf.MinY, conf.MaxY, conf.CountY)
+	for _, player := range wm.Players {
+		aoiMgr.AddPidToGridByPos(int(player.PlayerID), player.X, player.Z)
+	}
+	wm.AOIMgr = aoiMgr
+	return nil
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWorldManager_ResetAOI(t *testing.T) {
+	wm := &WorldManager{
+		AOIMgr:  NewAOIManager(0, 100, 10, 0, 100, 10),
+		Players: make(map[int32]*Player),
+	}
+	wm.AddPlayer(&Player{PlayerID: 1, X: 5, Z: 5})
+	wm.AddPlayer(&Player{PlayerID: 2, X: 25, Z: 5})
+
+	// 格子较小时两个玩家不在同一个九宫格中
+	if pids := wm.GetAOIMgr().GetPidByPos(5, 5); len(pids) != 1 {
+		t.Fatalf("surround players = %v, want only player 1", pids)
+	}
+
+	conf, err := ParseGameConf(json.RawMessage(`{"aoi": {"min_x": 0, "max_x": 100, "count_x": 2, "min_y": 0, "max_y": 100, "count_y": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wm.ResetAOI(conf.AOI); err != nil {
+		t.Fatal(err)
+	}
+	if pids := wm.GetAOIMgr().GetPidByPos(5, 5); len(pids) != 2 {
+		t.Errorf("surround players after reset = %v, want players 1 and 2", pids)
+	}
+
+	if err := wm.ResetAOI(AOIConf{MinX: 0, MaxX: 1, CountX: 10, MinY: 0, MaxY: 100, CountY: 10}); err == nil {
+		t.Error("invalid aoi config accepted")
+	}
+}
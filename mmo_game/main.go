@@ -1,10 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"flag"
 	"mmo_game/apis"
 	"mmo_game/core"
+	"os"
+	"time"
+	"zinx/utils"
 	"zinx/ziface"
+	"zinx/zlog"
 	"zinx/znet"
 )
 
@@ -28,7 +33,7 @@ func OnConnectionStart(conn ziface.IConnection) {
 	// 同步周围玩家，告知他们当前玩家已经上线，广播当前玩家的位置信息
 	player.SyncSurrounding()
 
-	fmt.Println("PlayerID =", player.PlayerID, "has arrived")
+	zlog.Info("Player has arrived", zlog.Any("playerID", player.PlayerID), zlog.ConnID(conn.GetConnID()))
 }
 
 // OnConnectionStop 连接断开之前调用的Hook函数
@@ -40,21 +45,64 @@ func OnConnectionStop(conn ziface.IConnection) {
 	// 触发玩家下线的业务
 	player.Offline()
 
-	fmt.Println("PlayerID =", playerId, "offline...")
+	zlog.Info("Player offline", zlog.Any("playerID", playerId), zlog.ConnID(conn.GetConnID()))
+}
+
+// applyGameConf 解析zinx配置文件中的app字段，并按照其中的AOI配置重建世界地图AOI
+func applyGameConf(data json.RawMessage) error {
+	conf, err := core.ParseGameConf(data)
+	if err != nil {
+		return err
+	}
+	if err := core.WorldMgrObj.ResetAOI(conf.AOI); err != nil {
+		return err
+	}
+	zlog.Info("Apply AOI config", zlog.Any("aoi", conf.AOI))
+	return nil
 }
 
 func main() {
-	// 创建zinx Server实例
-	s := znet.NewServer()
+	// 加载配置文件，可以通过命令行参数-zinx_config或者环境变量ZINX_CONFIG指定路径
+	flag.Parse()
+	if err := utils.Load(""); err != nil {
+		zlog.Error("Load config error", zlog.Err(err))
+		os.Exit(1)
+	}
+
+	// 按照配置创建世界地图AOI，配置文件修改之后重新加载
+	if err := applyGameConf(utils.GetGlobalObject().App); err != nil {
+		zlog.Error("Load game config error", zlog.Err(err))
+		os.Exit(1)
+	}
+	utils.Subscribe(func(change *utils.ConfigChange) {
+		if !change.Changed("app") {
+			return
+		}
+		if err := applyGameConf(change.New.App); err != nil {
+			zlog.Error("Reload game config error", zlog.Err(err))
+		}
+	})
+	stopWatch := utils.WatchConfig(5 * time.Second)
+	defer stopWatch()
+
+	// 创建zinx Server实例，注册连接创建和销毁的HOOK钩子函数
+	s := znet.NewServer(
+		znet.WithOnConnStart(OnConnectionStart),
+		znet.WithOnConnStop(OnConnectionStop),
+	)
+	// 给多个玩家广播消息时通过ConnManager只封包一次
+	core.WorldMgrObj.SetConnManager(s.GetConnManager())
 
-	// 连接创建和销毁的HOOK钩子函数
-	s.SetOnConnStart(OnConnectionStart)
-	s.SetOnConnStop(OnConnectionStop)
+	// 注册全局中间件：只处理已经绑定了在线玩家的连接的请求
+	s.Use(apis.PlayerAuth)
 
 	// 注册一些路由业务
 	s.AddRouter(2, &apis.WorldChatApi{})
 	s.AddRouter(3, &apis.MoveApi{})
 
 	// 启动服务
-	s.Serve()
+	if err := s.Serve(); err != nil {
+		zlog.Error("Serve error", zlog.Err(err))
+		os.Exit(1)
+	}
 }
@@ -1,3 +1,10 @@
 module zinx
 
-go 1.15
\ No newline at end of file
+go 1.15
+
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/gorilla/websocket v1.4.2
+	github.com/xtaci/kcp-go/v5 v5.6.1
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
+)
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"zinx/zlog"
+)
+
+// DefaultConfFile 没有通过参数、命令行或者环境变量指定时使用的配置文件路径
+const DefaultConfFile = "conf/zinx.json"
+
+// ConfEnv 指定配置文件路径的环境变量
+const ConfEnv = "ZINX_CONFIG"
+
+// EnvPrefix 覆盖配置的环境变量前缀，例如ZINX_PORT覆盖port，ZINX_WORKER_POOL_SIZE覆盖worker_pool_size
+const EnvPrefix = "ZINX_"
+
+// confFlag 指定配置文件路径的命令行参数，需要应用在Load之前调用flag.Parse
+var confFlag = flag.String("zinx_config", "", "path of the zinx config file (default "+DefaultConfFile+")")
+
+// Load 加载配置并替换全局配置
+// 配置文件的路径依次取参数path、命令行参数-zinx_config、环境变量ZINX_CONFIG，都没有指定时使用conf/zinx.json
+// 指定的配置文件不存在时返回错误，默认的配置文件不存在时使用默认值
+// 之后使用ZINX_开头的环境变量覆盖配置，校验通过之后才会生效
+func Load(path string) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	conf, err := LoadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// 按照配置设置日志
+	if err := zlog.Setup(conf.LogLevel, conf.LogJSON, conf.LogFile, conf.LogMaxSize, conf.LogMaxBackups); err != nil {
+		return err
+	}
+
+	globalObject.Store(conf)
+	return nil
+}
+
+// LoadFile 按照Load的规则加载配置，但不替换全局配置
+func LoadFile(path string) (*GlobalObj, error) {
+	explicit := true
+	if path == "" {
+		path = *confFlag
+	}
+	if path == "" {
+		path = os.Getenv(ConfEnv)
+	}
+	if path == "" {
+		path = DefaultConfFile
+		explicit = false
+	}
+
+	conf := NewDefaultGlobalObj()
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		// 解析Json数据
+		if err := json.Unmarshal(data, conf); err != nil {
+			return nil, fmt.Errorf("parse zinx config %s error: %v", path, err)
+		}
+		conf.ConfFile = path
+	case os.IsNotExist(err) && !explicit:
+		// 默认的配置文件不存在，使用默认值
+	default:
+		return nil, fmt.Errorf("read zinx config error: %v", err)
+	}
+
+	if err := conf.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// applyEnv 使用ZINX_开头的环境变量覆盖配置，变量名为Json字段名的大写形式
+// 字符串类型的字段直接使用变量的值，其他类型的字段按照Json解析变量的值
+func (g *GlobalObj) applyEnv(environ []string) error {
+	envs := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 && strings.HasPrefix(kv, EnvPrefix) {
+			envs[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	v := reflect.ValueOf(g).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := EnvPrefix + strings.ToUpper(tag)
+		value, ok := envs[name]
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+			continue
+		}
+		if err := json.Unmarshal([]byte(value), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("parse environment variable %s=%q error: %v", name, value, err)
+		}
+	}
+	return nil
+}
+
+// Validate 校验配置，返回所有不合法的配置项
+func (g *GlobalObj) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Sprintf("%s %q must be one of %s", name, value, strings.Join(allowed, ", ")))
+	}
+
+	check(g.Port > 0 && g.Port <= 65535, "port %d must be in 1-65535", g.Port)
+	check(g.WsPort >= 0 && g.WsPort <= 65535, "ws_port %d must be in 0-65535", g.WsPort)
+	check(g.MetricsPort >= 0 && g.MetricsPort <= 65535, "metrics_port %d must be in 0-65535", g.MetricsPort)
+	check(g.WsPort == 0 || g.WsPort != g.Port || g.Transport == "kcp", "ws_port %d must differ from port", g.WsPort)
+	check(strings.HasPrefix(g.WsPath, "/"), "ws_path %q must start with /", g.WsPath)
+	oneOf("transport", g.Transport, "tcp", "kcp")
+	check(g.MaxConn > 0, "max_conn %d must be positive", g.MaxConn)
+	check(g.WorkerPoolSize <= g.MaxWorkerPoolSize,
+		"worker_pool_size %d must not exceed max_worker_pool_size %d", g.WorkerPoolSize, g.MaxWorkerPoolSize)
+	check(g.WorkerPoolSize == 0 || g.MaxWorkerPoolSize > 0, "max_worker_pool_size must be positive when worker_pool_size > 0")
+	oneOf("dispatch_mode", g.DispatchMode, "conn", "key", "least_loaded", "logic")
+	if g.AutoscaleMaxWorkers > 0 {
+		check(g.AutoscaleMinWorkers <= g.AutoscaleMaxWorkers, "autoscale_min_workers %d must not exceed autoscale_max_workers %d",
+			g.AutoscaleMinWorkers, g.AutoscaleMaxWorkers)
+		check(g.AutoscaleInterval > 0, "autoscale_interval must be positive when autoscale_max_workers is set")
+	}
+	oneOf("task_queue_policy", g.TaskQueuePolicy, "block", "drop", "busy", "disconnect")
+	check(g.AdmissionThreshold >= 0 && g.AdmissionThreshold <= 1, "admission_threshold %v must be in 0-1", g.AdmissionThreshold)
+	check(g.DrainTimeout > 0, "drain_timeout must be positive")
+	check(g.IdleTimeout == 0 || g.HeartbeatInterval > 0, "heartbeat_interval must be positive when idle_timeout is set")
+	oneOf("send_buff_policy", g.SendBuffPolicy, "block", "drop_newest", "drop_oldest", "disconnect")
+	oneOf("panic_policy", g.PanicPolicy, "continue", "close_conn", "crash")
+	oneOf("rate_limit_action", g.RateLimitAction, "drop", "delay", "disconnect")
+	check(g.GlobalRateLimit.Rate >= 0 && g.ConnRateLimit.Rate >= 0, "rate limit rate must not be negative")
+	for msgId, conf := range g.MsgRateLimits {
+		check(conf.Rate >= 0, "msg_rate_limits of msg id %d: rate must not be negative", msgId)
+	}
+	check((g.TLSCertFile == "") == (g.TLSKeyFile == ""), "tls_cert_file and tls_key_file must be set together")
+	if g.TLSClientAuth != "" {
+		oneOf("tls_client_auth", g.TLSClientAuth, "none", "verify_if_given", "require")
+	}
+	if _, err := zlog.ParseLevel(g.LogLevel); err != nil {
+		errs = append(errs, err.Error())
+	}
+	check(g.LogFile == "" || g.LogMaxSize >= 0, "log_max_size must not be negative")
+
+	if len(errs) > 0 {
+		return errors.New("invalid zinx config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConf 在临时目录中写入一个配置文件
+func writeConf(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "zinx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "zinx.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// 配置文件中的值覆盖默认值，没有配置的字段保持默认值
+func TestLoadFile(t *testing.T) {
+	path := writeConf(t, `{"name": "Test Server", "port": 7777, "worker_pool_size": 4}`)
+	conf, err := LoadFile(path)
+	if err != nil {
+		t.Fatal("LoadFile error:", err)
+	}
+	if conf.Name != "Test Server" || conf.Port != 7777 || conf.WorkerPoolSize != 4 || conf.ConfFile != path {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+	if conf.MaxConn != NewDefaultGlobalObj().MaxConn {
+		t.Error("max_conn should keep the default value, got", conf.MaxConn)
+	}
+
+	// 指定的配置文件不存在时返回错误
+	if _, err := LoadFile(filepath.Join(filepath.Dir(path), "missing.json")); err == nil {
+		t.Error("missing explicit config file should return an error")
+	}
+}
+
+// 默认的配置文件不存在时使用默认值
+func TestLoadFile_Absent(t *testing.T) {
+	conf, err := LoadFile("")
+	if err != nil {
+		t.Fatal("LoadFile error:", err)
+	}
+	if conf.Port != 8999 || conf.ConfFile != "" {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+}
+
+// ZINX_开头的环境变量覆盖配置文件中的值
+func TestLoadFile_Env(t *testing.T) {
+	path := writeConf(t, `{"port": 7777}`)
+	conf := NewDefaultGlobalObj()
+	err := conf.applyEnv([]string{
+		"ZINX_PORT=6666",
+		"ZINX_NAME=Env Server",
+		"ZINX_LOG_JSON=true",
+		`ZINX_MSG_RATE_LIMITS={"3": {"rate": 20}}`,
+		"OTHER_PORT=1",
+	})
+	if err != nil {
+		t.Fatal("applyEnv error:", err)
+	}
+	if conf.Port != 6666 || conf.Name != "Env Server" || !conf.LogJSON || conf.MsgRateLimits[3].Rate != 20 {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+
+	if err := conf.applyEnv([]string{"ZINX_PORT=abc"}); err == nil || !strings.Contains(err.Error(), "ZINX_PORT") {
+		t.Error("invalid environment variable should return an error naming it, got", err)
+	}
+
+	os.Setenv("ZINX_WORKER_POOL_SIZE", "2")
+	defer os.Unsetenv("ZINX_WORKER_POOL_SIZE")
+	if conf, err := LoadFile(path); err != nil || conf.Port != 7777 || conf.WorkerPoolSize != 2 {
+		t.Errorf("LoadFile with env: %+v, %v", conf, err)
+	}
+}
+
+// 不合法的配置返回清晰的错误
+func TestGlobalObj_Validate(t *testing.T) {
+	if err := NewDefaultGlobalObj().Validate(); err != nil {
+		t.Error("default config should be valid:", err)
+	}
+
+	conf := NewDefaultGlobalObj()
+	conf.WorkerPoolSize = 2048
+	conf.Transport = "udp"
+	err := conf.Validate()
+	if err == nil {
+		t.Fatal("invalid config should not pass validation")
+	}
+	for _, want := range []string{"worker_pool_size 2048 must not exceed max_worker_pool_size 1024", `transport "udp"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should contain %q", err, want)
+		}
+	}
+
+	path := writeConf(t, `{"worker_pool_size": 2048}`)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("LoadFile should validate the config")
+	}
+}
@@ -2,56 +2,120 @@ package utils
 
 import (
 	"encoding/json"
-	"io/ioutil"
-	"zinx/ziface"
+	"sync/atomic"
 )
 
-// 存储一切有关Zinx框架的全局参数，供其他模块使用
-// 参数可以通过Json由用户进行配置
+// 存储一切有关Zinx框架的参数，Server在创建时复制一份，也可以通过Option为每个Server单独指定
+// 参数可以通过Json由用户进行配置，带有reload:"hot"标签的参数可以在运行时重新加载，其余的参数需要重启才能生效
+// 其中心跳、空闲超时、发送队列长度和串行执行队列长度在创建连接时读取，重新加载之后对新建立的连接生效
 
 type GlobalObj struct {
-	TcpServer ziface.IServer // 当前Zinx全局的Server对象
-	IP        string         `json:"ip"`   // 当前服务器监听的IP
-	Port      int            `json:"port"` // 当前服务器监听的端口号
-	Name      string         `json:"name"` // 当前服务器名称
-
-	Version           string `json:"version"`              // 当前Zinx的版本号
-	MaxConn           int    `json:"max_conn"`             // 当前服务器允许的最大连接数
-	MaxPackageSize    uint32 `json:"max_package_size"`     // 当前Zinx数据包的最大值
-	WorkerPoolSize    uint32 `json:"worker_pool_size"`     // 当前业务工作Worker池中Goroutine数量
-	MaxWorkerPoolSize uint32 `json:"max_worker_pool_size"` // Zinx框架允许用户最多开辟多少个Goroutine
+	ConfFile  string `json:"-"`         // 当前加载的配置文件路径，没有加载配置文件时为空
+	IP        string `json:"ip"`        // 当前服务器监听的IP
+	Port      int    `json:"port"`      // 当前服务器监听的端口号
+	Name      string `json:"name"`      // 当前服务器名称
+	Transport string `json:"transport"` // 当前服务器监听使用的传输协议：tcp、kcp
+	WsPort    int    `json:"ws_port"`   // 当前服务器WebSocket监听的端口号，为0则不开启WebSocket
+	WsPath    string `json:"ws_path"`   // 当前服务器WebSocket监听的路径
+
+	TLSCertFile     string `json:"tls_cert_file"`      // TLS证书文件路径，为空则不开启TLS
+	TLSKeyFile      string `json:"tls_key_file"`       // TLS私钥文件路径
+	TLSClientCAFile string `json:"tls_client_ca_file"` // 用于校验客户端证书的CA证书文件路径（mTLS）
+	TLSClientAuth   string `json:"tls_client_auth"`    // 客户端证书的校验策略：none、verify_if_given、require
+
+	Version             string  `json:"version"`                          // 当前Zinx的版本号
+	MaxConn             int     `json:"max_conn" reload:"hot"`            // 当前服务器允许的最大连接数
+	MaxPackageSize      uint32  `json:"max_package_size" reload:"hot"`    // 当前Zinx数据包的最大值
+	WorkerPoolSize      uint32  `json:"worker_pool_size"`                 // 当前业务工作Worker池中Goroutine数量
+	MaxWorkerPoolSize   uint32  `json:"max_worker_pool_size"`             // Zinx框架允许用户最多开辟多少个Goroutine
+	DispatchMode        string  `json:"dispatch_mode"`                    // 请求分发给Worker的策略：conn、key、least_loaded、logic
+	AutoscaleMinWorkers uint32  `json:"autoscale_min_workers"`            // 自动伸缩时Worker的最小数量
+	AutoscaleMaxWorkers uint32  `json:"autoscale_max_workers"`            // 自动伸缩时Worker的最大数量，为0则不自动伸缩
+	AutoscaleInterval   uint32  `json:"autoscale_interval"`               // 自动伸缩检查的时间间隔（毫秒）
+	TaskQueuePolicy     string  `json:"task_queue_policy" reload:"hot"`   // 消息队列已满时的处理策略：block、drop、busy、disconnect
+	TaskQueueTimeout    uint32  `json:"task_queue_timeout" reload:"hot"`  // block策略等待消息队列有空位的最长时间（毫秒），为0则一直等待
+	AdmissionThreshold  float64 `json:"admission_threshold" reload:"hot"` // 消息队列的饱和度（0-1）达到该值时拒绝新的连接，为0则不限制
+	OrderedPerConn      bool    `json:"ordered_per_conn"`                 // 没有开启Worker工作池时，同一个连接的请求是否在一个Goroutine中按顺序处理，为false则每个请求一个Goroutine
+	OrderedQueueLen     uint32  `json:"ordered_queue_len" reload:"hot"`   // 按顺序处理时每个连接的串行执行队列的长度，队列已满时Reader等待
+	DrainTimeout        uint32  `json:"drain_timeout" reload:"hot"`       // 连接停止时等待已分发请求处理完毕的最长时间（毫秒）
+	HeartbeatInterval   uint32  `json:"heartbeat_interval" reload:"hot"`  // 检测连接是否空闲超时的时间间隔（毫秒）
+	IdleTimeout         uint32  `json:"idle_timeout" reload:"hot"`        // 连接允许的最长空闲时间（毫秒），超过则认为连接已经失效，默认60秒，为0则不检测
+	MaxMsgChanLen       uint32  `json:"max_msg_chan_len" reload:"hot"`    // 每个连接带缓冲的发送队列的最大长度
+	SendBuffPolicy      string  `json:"send_buff_policy" reload:"hot"`    // 发送队列已满时的处理策略：block、drop_newest、drop_oldest、disconnect
+	PanicPolicy         string  `json:"panic_policy"`                     // 业务处理发生panic之后的处理策略：continue、close_conn、crash
+
+	Encryption        bool     `json:"encryption"`                      // 是否要求客户端在连接之后先进行密钥交换，此后的数据全部加密（用于不能使用TLS的客户端）
+	Compressions      []string `json:"compressions" reload:"hot"`       // 允许与客户端协商使用的压缩算法，为空则不压缩
+	CompressThreshold uint32   `json:"compress_threshold" reload:"hot"` // 消息内容达到该长度（字节）时才进行压缩
+
+	GlobalRateLimit RateLimitConf            `json:"global_rate_limit"` // 所有连接合计的限流配置
+	ConnRateLimit   RateLimitConf            `json:"conn_rate_limit"`   // 每个连接的限流配置
+	MsgRateLimits   map[uint32]RateLimitConf `json:"msg_rate_limits"`   // 每个连接中指定MsgID的限流配置，key为MsgID
+	RateLimitAction string                   `json:"rate_limit_action"` // 超出限流之后的处理策略：drop、delay、disconnect
+
+	MetricsPort int `json:"metrics_port"` // 以Prometheus文本格式提供统计数据的本地HTTP端口，为0则不开启
+
+	LogLevel      string `json:"log_level" reload:"hot"`       // 日志级别：debug、info、warn、error
+	LogJSON       bool   `json:"log_json" reload:"hot"`        // 日志是否以JSON格式输出
+	LogFile       string `json:"log_file" reload:"hot"`        // 日志文件路径，为空则输出到标准输出
+	LogMaxSize    int    `json:"log_max_size" reload:"hot"`    // 单个日志文件的最大MB数，超过则切割
+	LogMaxBackups int    `json:"log_max_backups" reload:"hot"` // 切割之后保留的旧日志文件数
+
+	App json.RawMessage `json:"app" reload:"hot"` // 应用自定义的配置（例如游戏的AOI参数），由应用自己解析
 }
 
-// GlobalObject 对外的全局变量
-var GlobalObject *GlobalObj
+// RateLimitConf 令牌桶限流的配置
+type RateLimitConf struct {
+	Rate  float64 `json:"rate"`  // 每秒允许的消息数，为0则不限流
+	Burst int     `json:"burst"` // 允许突发的最大消息数（令牌桶的容量），为0则与Rate相同
+}
 
-// 初始化当前的GlobalObject
-func init() {
-	// 如果配置文件没有加载，默认的值
-	GlobalObject = &GlobalObj{
+// DefaultMaxPackageSize 默认允许的最大包长度
+const DefaultMaxPackageSize = 4096
+
+// globalObject 通过Load加载的配置（*GlobalObj），创建Server时作为默认的配置，重新加载配置时整体替换
+var globalObject atomic.Value
+
+// GetGlobalObject 获取当前的全局配置，重新加载配置之后会得到新的配置，不能修改
+func GetGlobalObject() *GlobalObj {
+	return globalObject.Load().(*GlobalObj)
+}
+
+// NewDefaultGlobalObj 创建一个全部为默认值的配置
+func NewDefaultGlobalObj() *GlobalObj {
+	return &GlobalObj{
 		Name:              "ZinServerApp",
 		Version:           "V1.0",
 		Port:              8999,
 		IP:                "0.0.0.0",
+		Transport:         "tcp",
+		WsPort:            0,
+		WsPath:            "/",
 		MaxConn:           1000,
-		MaxPackageSize:    4096,
+		MaxPackageSize:    DefaultMaxPackageSize,
 		WorkerPoolSize:    10,   // Worker工作池队列的个数
 		MaxWorkerPoolSize: 1024, // 每个Worker对应的消息队列的任务数量最大值
+		DispatchMode:      "conn",
+		AutoscaleInterval: 1000, // 每秒检查一次是否需要调整Worker的数量
+		TaskQueuePolicy:   "block",
+		OrderedPerConn:    true,
+		OrderedQueueLen:   1024,
+		DrainTimeout:      5000,  // 停止连接时最多等待5秒
+		HeartbeatInterval: 1000,  // 每秒检测一次连接是否空闲超时
+		IdleTimeout:       60000, // 60秒没有收到任何数据（包括心跳）的连接被认为已经失效
+		MaxMsgChanLen:     1024,
+		SendBuffPolicy:    "block",
+		PanicPolicy:       "continue",
+		Compressions:      []string{"snappy"},
+		CompressThreshold: 256, // 较小的消息压缩收益不大
+		RateLimitAction:   "drop",
+		LogLevel:          "info",
+		LogMaxSize:        100,
+		LogMaxBackups:     5,
 	}
-
-	// 应该尝试从配置文件中去加载一些用户自定义的参数
-	GlobalObject.Reload()
 }
 
-// Reload 从配置文件中加载用户自定义的参数
-func (g *GlobalObj) Reload() {
-	data, err := ioutil.ReadFile("conf/zinx.json")
-	if err != nil {
-		panic(err)
-	}
-	// 解析Json数据
-	err = json.Unmarshal(data, GlobalObject)
-	if err != nil {
-		panic(err)
-	}
+// 初始化当前的全局配置，只使用默认值，配置文件需要通过Load显式地加载
+func init() {
+	globalObject.Store(NewDefaultGlobalObj())
 }
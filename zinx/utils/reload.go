@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"zinx/zlog"
+)
+
+// ConfigChange 一次重新加载配置的结果
+type ConfigChange struct {
+	Old             *GlobalObj // 重新加载之前的配置
+	New             *GlobalObj // 重新加载之后生效的配置
+	Applied         []string   // 已经生效的配置项（Json字段名）
+	RestartRequired []string   // 发生了变化但需要重启才能生效的配置项，这些配置项在New中保持原来的值
+}
+
+// Changed 判断名为name（Json字段名）的配置项是否已经生效了新的值
+func (c *ConfigChange) Changed(name string) bool {
+	return contains(c.Applied, name)
+}
+
+// subscriber 一个配置变化的订阅者
+type subscriber struct {
+	id       uint64
+	callback func(change *ConfigChange)
+}
+
+// ApplyTo 将已经生效的配置项复制到conf的副本中并返回，skip中的配置项（Json字段名）保持conf原来的值
+// 用于Server等持有自己的一份配置的模块跟随重新加载的配置
+func (c *ConfigChange) ApplyTo(conf *GlobalObj, skip ...string) *GlobalObj {
+	newConf := *conf
+	newValue := reflect.ValueOf(&newConf).Elem()
+	srcValue := reflect.ValueOf(c.New).Elem()
+	t := newValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if !c.Changed(name) || contains(skip, name) {
+			continue
+		}
+		newValue.Field(i).Set(srcValue.Field(i))
+	}
+	return &newConf
+}
+
+var (
+	subscribers     []subscriber // 配置变化的订阅者，按照注册的顺序调用
+	subscriberIDGen uint64       // 用来生成订阅者ID的计数器
+	subscribersLock sync.RWMutex // 保护订阅者的锁
+	reloadLock      sync.Mutex   // 保证同一时间只有一次加载或者重新加载
+)
+
+// Subscribe 注册一个配置变化的回调，每次重新加载配置之后，只要有配置项发生变化就会被调用
+// 返回取消订阅的函数
+func Subscribe(callback func(change *ConfigChange)) (unsubscribe func()) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+
+	subscriberIDGen++
+	id := subscriberIDGen
+	subscribers = append(subscribers, subscriber{id: id, callback: callback})
+
+	return func() {
+		subscribersLock.Lock()
+		defer subscribersLock.Unlock()
+
+		for i, sub := range subscribers {
+			if sub.id == id {
+				// 复制一份新的切片，不影响正在通知的订阅者
+				newSubscribers := make([]subscriber, 0, len(subscribers)-1)
+				newSubscribers = append(newSubscribers, subscribers[:i]...)
+				subscribers = append(newSubscribers, subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Reload 重新读取当前的配置文件（同样应用环境变量的覆盖），并使可以热更新的配置项生效
+// 只有带reload:"hot"标签的配置项会被更新，其余发生变化的配置项记录在RestartRequired中，保持原来的值
+// 新的配置校验通过之后整体替换全局配置，校验失败则返回错误，当前的配置不受影响
+// 订阅者在替换配置之后、释放重新加载的锁之后调用，较慢的订阅者不会阻塞其他的重新加载
+func Reload() (*ConfigChange, error) {
+	change, err := reload()
+	if err != nil {
+		return nil, err
+	}
+	if len(change.Applied) == 0 && len(change.RestartRequired) == 0 {
+		return change, nil
+	}
+
+	subscribersLock.RLock()
+	subs := subscribers
+	subscribersLock.RUnlock()
+	for _, sub := range subs {
+		sub.callback(change)
+	}
+	return change, nil
+}
+
+// reload 在重新加载的锁中读取配置文件并替换全局配置
+func reload() (*ConfigChange, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	old := GetGlobalObject()
+	conf, err := LoadFile(old.ConfFile)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &ConfigChange{Old: old}
+	newConf := *old
+	oldValue := reflect.ValueOf(old).Elem()
+	confValue := reflect.ValueOf(conf).Elem()
+	newValue := reflect.ValueOf(&newConf).Elem()
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), confValue.Field(i).Interface()) {
+			continue
+		}
+		if t.Field(i).Tag.Get("reload") != "hot" {
+			change.RestartRequired = append(change.RestartRequired, name)
+			continue
+		}
+		newValue.Field(i).Set(confValue.Field(i))
+		change.Applied = append(change.Applied, name)
+	}
+	newConf.ConfFile = conf.ConfFile
+	if err := newConf.Validate(); err != nil {
+		return nil, err
+	}
+	change.New = &newConf
+
+	// 日志的配置发生变化时重新设置日志
+	if change.Changed("log_level") || change.Changed("log_json") || change.Changed("log_file") ||
+		change.Changed("log_max_size") || change.Changed("log_max_backups") {
+		if err := zlog.Setup(newConf.LogLevel, newConf.LogJSON, newConf.LogFile, newConf.LogMaxSize, newConf.LogMaxBackups); err != nil {
+			return nil, err
+		}
+	}
+
+	globalObject.Store(&newConf)
+
+	if len(change.Applied) > 0 {
+		zlog.Info("Zinx config reloaded", zlog.Any("file", newConf.ConfFile), zlog.Any("applied", strings.Join(change.Applied, ",")))
+	}
+	if len(change.RestartRequired) > 0 {
+		zlog.Warn("Zinx config changes require restart", zlog.Any("file", newConf.ConfFile),
+			zlog.Any("fields", strings.Join(change.RestartRequired, ",")))
+	}
+	return change, nil
+}
+
+// WatchConfig 每隔interval检查一次当前的配置文件，修改时间或者大小发生变化时调用Reload
+// 返回停止检查的函数
+func WatchConfig(interval time.Duration) (stop func()) {
+	exitChan := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		path := GetGlobalObject().ConfFile
+		lastMod, lastSize := statConfFile(path)
+		for {
+			select {
+			case <-ticker.C:
+			case <-exitChan:
+				return
+			}
+
+			if file := GetGlobalObject().ConfFile; file != path {
+				path = file
+				lastMod, lastSize = statConfFile(path)
+				continue
+			}
+			mod, size := statConfFile(path)
+			if mod.Equal(lastMod) && size == lastSize {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			if _, err := Reload(); err != nil {
+				zlog.Error("Reload zinx config error", zlog.Any("file", path), zlog.Err(err))
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			close(exitChan)
+		})
+	}
+}
+
+// statConfFile 获取配置文件的修改时间和大小，路径为空时使用默认的配置文件，文件不存在时返回零值
+func statConfFile(path string) (time.Time, int64) {
+	if path == "" {
+		path = DefaultConfFile
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// jsonName 获取配置项的Json字段名，不参与Json解析的字段返回空字符串
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// contains 判断names中是否包含name
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
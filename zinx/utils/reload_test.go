@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 可以热更新的配置项生效，需要重启的配置项保持原来的值并被报告，订阅者收到变化
+func TestReload(t *testing.T) {
+	old := GetGlobalObject()
+	t.Cleanup(func() { globalObject.Store(old) })
+
+	path := writeConf(t, `{"port": 7777, "max_conn": 10, "app": {"aoi": {"count_x": 10}}}`)
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan *ConfigChange, 1)
+	unsubscribe := Subscribe(func(change *ConfigChange) {
+		changes <- change
+	})
+	defer unsubscribe()
+
+	content := `{"port": 8888, "max_conn": 20, "app": {"aoi": {"count_x": 20}}}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	change, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"max_conn", "app"}; !reflect.DeepEqual(change.Applied, want) {
+		t.Errorf("Applied = %v, want %v", change.Applied, want)
+	}
+	if want := []string{"port"}; !reflect.DeepEqual(change.RestartRequired, want) {
+		t.Errorf("RestartRequired = %v, want %v", change.RestartRequired, want)
+	}
+	if conf := GetGlobalObject(); conf != change.New || conf.MaxConn != 20 || conf.Port != 7777 {
+		t.Errorf("global config max_conn = %d, port = %d, want 20, 7777", conf.MaxConn, conf.Port)
+	}
+	if app := GetGlobalObject().App; string(app) != `{"aoi": {"count_x": 20}}` {
+		t.Errorf("global config app = %s", app)
+	}
+
+	select {
+	case got := <-changes:
+		if got != change {
+			t.Error("subscriber received a different change")
+		}
+	default:
+		t.Error("subscriber is not notified")
+	}
+
+	// ApplyTo只复制已经生效并且没有被跳过的配置项
+	conf := change.ApplyTo(old, "app")
+	if conf.MaxConn != 20 || conf.Port != old.Port || string(conf.App) != string(old.App) {
+		t.Errorf("ApplyTo max_conn = %d, port = %d, app = %s", conf.MaxConn, conf.Port, conf.App)
+	}
+
+	// 不合法的配置不会生效
+	if err := ioutil.WriteFile(path, []byte(`{"port": 7777, "max_conn": -1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reload(); err == nil {
+		t.Error("invalid config reloaded")
+	}
+	if GetGlobalObject() != change.New {
+		t.Error("global config replaced by invalid config")
+	}
+}
+
+// 订阅者在释放重新加载的锁之后调用，较慢的订阅者不会阻塞下一次重新加载和读取配置
+func TestReload_SlowSubscriber(t *testing.T) {
+	old := GetGlobalObject()
+	t.Cleanup(func() { globalObject.Store(old) })
+
+	path := writeConf(t, `{"max_conn": 10}`)
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	unsubscribe := Subscribe(func(change *ConfigChange) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+			<-release
+		}
+	})
+	defer unsubscribe()
+
+	// 第一次重新加载阻塞在订阅者中
+	ioutil.WriteFile(path, []byte(`{"max_conn": 20}`), 0644)
+	firstDone := make(chan struct{})
+	go func() {
+		Reload()
+		close(firstDone)
+	}()
+	<-entered
+
+	ioutil.WriteFile(path, []byte(`{"max_conn": 30}`), 0644)
+	secondDone := make(chan struct{})
+	go func() {
+		Reload()
+		close(secondDone)
+	}()
+	select {
+	case <-secondDone:
+	case <-time.After(time.Second):
+		t.Fatal("reload blocked by a slow subscriber")
+	}
+	if maxConn := GetGlobalObject().MaxConn; maxConn != 30 {
+		t.Errorf("max_conn = %d, want 30", maxConn)
+	}
+
+	close(release)
+	<-firstDone
+}
@@ -0,0 +1,20 @@
+package ziface
+
+import "time"
+
+// IClient 定义一个客户端接口，负责连接服务器、封包拆包，并根据MsgID将服务器的消息交给Router处理
+type IClient interface {
+	Start()                                                                // 启动客户端（连接服务器，连接断开之后自动重连）
+	Stop()                                                                 // 停止客户端
+	AddRouter(msgId uint32, router IRouter)                                // 注册处理服务器消息的Router
+	Use(middlewares ...Middleware)                                         // 添加全局中间件
+	UseRouter(msgId uint32, middlewares ...Middleware)                     // 为指定消息添加中间件
+	SendMsg(msgId uint32, data []byte) error                               // 发送消息给服务器
+	Call(msgId uint32, data []byte, timeout time.Duration) ([]byte, error) // 发送RPC请求，阻塞等待服务器的回复或者超时
+	IsConnected() bool                                                     // 当前是否已经连接上服务器
+	GetConnection() IConnection                                            // 获取客户端的连接，Router中的Request得到的也是这个连接
+	SetDataPack(dataPack IDataPack)                                        // 设置客户端的封包拆包模块，需要与服务器一致
+	SetOnConnect(func(conn IConnection))                                   // 注册OnConnect钩子函数的方法（每次连接上服务器之后调用）
+	SetOnDisconnect(func(conn IConnection))                                // 注册OnDisconnect钩子函数的方法（每次与服务器断开之后调用）
+	SetOnServerError(func(conn IConnection, err error))                    // 注册OnServerError钩子函数的方法（收到服务器的错误消息时调用）
+}
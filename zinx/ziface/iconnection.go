@@ -1,18 +1,29 @@
 package ziface
 
-import "net"
+import (
+	"crypto/x509"
+	"net"
+	"time"
+)
 
 // IConnection 定义连接模块的抽象层
 type IConnection interface {
-	Start()                                      // 启动连接（让当前的连接准备开始工作）
-	Stop()                                       // 停止连接（结束当前连接的工作）
-	GetTCPConnection() *net.TCPConn              // 获取当前连接所绑定的socket
-	GetConnID() uint32                           // 获取当前连接模块的ID
-	RemoteAddr() net.Addr                        // 获取远程客户端的TCP状态（包括IP和端口）
-	SendMsg(msgId uint32, data []byte) error     // 发送数据（将数据发送给远程的客户端）
-	SetProperty(key string, value interface{})   // 设置连接属性
-	GetProperty(key string) (interface{}, error) // 获取连接属性
-	RemoveProperty(key string)                   // 删除连接属性
+	Start()                                                 // 启动连接（让当前的连接准备开始工作）
+	Stop()                                                  // 停止连接（结束当前连接的工作）
+	GetTCPConnection() *net.TCPConn                         // 获取当前连接所绑定的TCP socket，不是TCP连接时返回nil
+	GetConn() net.Conn                                      // 获取当前连接所绑定的socket（TCP、WebSocket等）
+	GetPeerCertificate() *x509.Certificate                  // 获取TLS握手时客户端提供的证书，没有开启TLS或者客户端没有提供证书时返回nil
+	GetConnID() uint32                                      // 获取当前连接模块的ID
+	RemoteAddr() net.Addr                                   // 获取远程客户端的TCP状态（包括IP和端口）
+	SendMsg(msgId uint32, data []byte) error                // 发送数据（将数据发送给远程的客户端）
+	SendBuffMsg(msgId uint32, data []byte) error            // 发送数据（先放入带缓冲的发送队列，不等待Writer发送）
+	SendSeqMsg(msgId uint32, seq uint32, data []byte) error // 发送带序列号的数据（用于RPC的请求和回复）
+	SetProperty(key string, value interface{})              // 设置连接属性
+	GetProperty(key string) (interface{}, error)            // 获取连接属性
+	RemoveProperty(key string)                              // 删除连接属性
+	GetLastActivity() time.Time                             // 获取当前连接最后一次收到客户端数据的时间
+	GetRateLimitedCount() uint64                            // 获取当前连接因为超出限流而被处理（丢弃、延迟或者断开）的消息数
+	Kick(reason string)                                     // 告知对端被踢出的原因，再停止连接
 }
 
 // HandleFunc 定义一个处理连接业务的方法
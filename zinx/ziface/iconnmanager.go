@@ -2,9 +2,13 @@ package ziface
 
 // IConnManager 连接管理抽象层
 type IConnManager interface {
-	Add(conn IConnection)                   // 添加连接
-	Remove(conn IConnection)                // 删除连接
-	Get(connId uint32) (IConnection, error) // 根据ConnID获取连接
-	Len() int                               // 得到当前连接总数
-	Clear()                                 // 清除并终止所有连接
+	Add(conn IConnection)                    // 添加连接
+	Remove(conn IConnection)                 // 删除连接
+	Get(connId uint32) (IConnection, error)  // 根据ConnID获取连接
+	Len() int                                // 得到当前连接总数
+	Clear()                                  // 清除并终止所有连接
+	Kick(connId uint32, reason string) error // 告知客户端被踢出的原因，再终止连接
+
+	Broadcast(msgId uint32, data []byte) map[uint32]error                   // 发送消息给所有连接，只封包一次，返回发送失败的连接及其错误
+	Multicast(connIds []uint32, msgId uint32, data []byte) map[uint32]error // 发送消息给指定的连接，只封包一次，返回发送失败的连接及其错误
 }
@@ -1,5 +1,7 @@
 package ziface
 
+import "io"
+
 /*
 	针对Message进行TLV格式的封包：
 	1、先写消息内容的长度和类型
@@ -15,7 +17,8 @@ package ziface
 // IDataPack 定义一个解决TCP粘包问题的封包拆包模块
 // 直接面向TCP连接的数据流，用于处理TCP粘包问题
 type IDataPack interface {
-	GetHeadLen() uint32                    // 获取包的head长度
-	Pack(message IMessage) ([]byte, error) // 封包
-	Unpack(data []byte) (IMessage, error)  // 拆包
+	GetHeadLen() uint32                         // 获取包的head长度（head长度可变时为最大长度）
+	Pack(message IMessage) ([]byte, error)      // 封包
+	Unpack(data []byte) (IMessage, error)       // 拆包
+	ReadMsg(reader io.Reader) (IMessage, error) // 从数据流中读取一个完整的消息（head和消息内容）
 }
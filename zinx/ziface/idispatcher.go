@@ -0,0 +1,26 @@
+package ziface
+
+// LogicQueue Dispatch返回该值时，请求交给单独的逻辑队列，由一个专门的Goroutine按顺序处理
+const LogicQueue = -1
+
+// IDispatcher 分发策略，决定开启Worker工作池时请求交给哪个消息队列处理
+// 同一个消息队列中的请求按照顺序处理，不同消息队列中的请求并发处理
+type IDispatcher interface {
+	// Dispatch 返回处理request的消息队列编号（0到pool.GetTaskQueueNum()-1）或者LogicQueue
+	// router为该消息注册的Router，没有注册时为nil
+	Dispatch(request IRequest, router IRouter, pool IWorkerPool) int
+}
+
+// IWorkerPool 分发策略可以获取的Worker工作池的状态
+type IWorkerPool interface {
+	GetTaskQueueNum() int            // 获取消息队列的数量
+	GetTaskQueueLen(queueId int) int // 获取指定消息队列中等待处理的请求数
+	GetWorkerNum() int               // 获取当前Worker的数量
+}
+
+// IKeyRouter 可以为请求提供分发key的Router（例如玩家ID、区域ID）
+// 按key分发时，相同key的请求交给同一个消息队列按顺序处理，ok为false时按照连接分发
+type IKeyRouter interface {
+	IRouter
+	DispatchKey(request IRequest) (key uint64, ok bool)
+}
@@ -0,0 +1,13 @@
+package ziface
+
+// IGroupManager 连接分组管理抽象层，用于公会聊天、副本、观战等需要给一组连接发送消息的业务
+// 一个连接可以同时加入多个分组，连接停止时自动离开全部分组
+type IGroupManager interface {
+	Join(group string, conn IConnection) error                          // 将连接加入分组，分组不存在时自动创建，连接已经开始停止时返回错误
+	Leave(group string, conn IConnection)                               // 将连接移出分组，分组中没有连接时自动删除
+	LeaveAll(conn IConnection)                                          // 将连接移出全部分组
+	GetMembers(group string) []IConnection                              // 获取分组中的全部连接
+	GetGroups(conn IConnection) []string                                // 获取连接加入的全部分组
+	GetGroupNames() []string                                            // 获取当前全部的分组名称
+	Broadcast(group string, msgId uint32, data []byte) map[uint32]error // 发送消息给分组中的全部连接，只封包一次，返回发送失败的连接及其错误
+}
@@ -5,7 +5,9 @@ type IMessage interface {
 	GetMsgID() uint32          // 获取消息的ID
 	GetDataLen() uint32        // 获取消息的长度
 	GetData() []byte           // 获取消息的内容
+	GetSeq() uint32            // 获取消息的序列号，用于将RPC的回复与请求对应，0表示不是RPC消息
 	SetMsgID(id uint32)        // 设置消息的ID
 	SetDataLen(dataLen uint32) // 设置消息的内容
 	SetData(data []byte)       // 设置消息的长度
+	SetSeq(seq uint32)         // 设置消息的序列号
 }
@@ -0,0 +1,8 @@
+package ziface
+
+// MsgHandleFunc 处理一个客户端请求的方法
+type MsgHandleFunc func(request IRequest)
+
+// Middleware 中间件，包装下一个处理方法，可以在其前后执行公共的业务（鉴权、日志、计时等）
+// 不调用next即可中断当前请求的处理
+type Middleware func(next MsgHandleFunc) MsgHandleFunc
@@ -1,9 +1,22 @@
 package ziface
 
+import "time"
+
 // IMsgHandler 消息处理模块的抽象接口
 type IMsgHandler interface {
-	DoMsgHandle(request IRequest)           // 调度/执行对应的Router消息处理方法
-	AddRouter(msgId uint32, router IRouter) // 为消息添加具体的处理逻辑
-	StartWorkerPool()                       // 启动Worker工作池
-	SendMsgToTaskQueue(request IRequest)    // 将消息发送给消息任务队列处理
+	DoMsgHandle(request IRequest)                                     // 调度/执行对应的Router消息处理方法
+	AddRouter(msgId uint32, router IRouter)                           // 为消息添加具体的处理逻辑
+	Use(middlewares ...Middleware)                                    // 添加全局中间件，对所有消息生效
+	UseRouter(msgId uint32, middlewares ...Middleware)                // 为指定消息添加中间件
+	StartWorkerPool()                                                 // 启动Worker工作池
+	StopWorkerPool()                                                  // 停止Worker工作池
+	SendMsgToTaskQueue(request IRequest, timeout time.Duration) error // 将消息发送给消息任务队列处理，队列已满时最多等待timeout
+	SetDispatcher(dispatcher IDispatcher)                             // 设置开启Worker工作池时的分发策略，需要在StartWorkerPool之前调用
+	SetPanicHandler(handler PanicHandler)                             // 设置业务处理发生panic之后的处理策略
+	GetPanicCount() uint64                                            // 获取已经恢复的panic次数
+	GetTaskQueueLens() []int                                          // 获取每个Worker的消息队列中等待处理的请求数
+	GetSaturation() float64                                           // 获取消息队列的饱和度（0-1），用于准入控制
 }
+
+// PanicHandler 业务处理发生panic并被恢复之后调用，决定后续如何处理（继续、关闭连接或者让进程退出）
+type PanicHandler func(request IRequest, recovered interface{})
@@ -0,0 +1,10 @@
+package ziface
+
+import "time"
+
+// IRateLimiter 定义限流模块的抽象层，限制客户端发送消息的速度
+type IRateLimiter interface {
+	Limit(conn IConnection, msgId uint32) (delay time.Duration, limited bool) // 连接收到一个消息时调用，limited为true表示超出了限制，delay为按照delay策略需要等待的时间
+	GetAction() string                                                        // 获取超出限制之后的处理策略：drop、delay、disconnect
+	RemoveConn(connID uint32)                                                 // 连接停止之后清理该连接的限流状态
+}
@@ -2,7 +2,10 @@ package ziface
 
 // IRequest 实际上是把客户端请求的连接信息和请求的数据包装起来
 type IRequest interface {
-	GetConnection() IConnection // 得到当前连接
-	GetData() []byte            // 得到请求的消息数据
-	GetMsgID() uint32           // 得到请求的消息ID
+	GetConnection() IConnection                  // 得到当前连接
+	GetData() []byte                             // 得到请求的消息数据
+	GetMsgID() uint32                            // 得到请求的消息ID
+	Reply(data []byte) error                     // 回复RPC请求，回复的消息使用与请求相同的消息ID和序列号
+	SetProperty(key string, value interface{})   // 设置请求属性，只在当前请求的处理过程中有效，用于中间件把结果传递给后续的业务
+	GetProperty(key string) (interface{}, error) // 获取请求属性
 }
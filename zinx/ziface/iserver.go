@@ -1,14 +1,26 @@
 package ziface
 
+import "zinx/utils"
+
 // IServer 定义一个服务器接口
 type IServer interface {
-	Start()                                 // 启动服务器
-	Stop()                                  // 停止服务器
-	Serve()                                 // 运行服务器
-	AddRouter(msgId uint32, router IRouter) // 给当前的服务注册一个Router，供客户端的连接处理使用
-	GetConnManager() IConnManager           // 获取当前Server的连接管理模块
-	SetOnConnStart(func(conn IConnection))  // 注册OnConnStart钩子函数的方法
-	SetOnConnStop(func(conn IConnection))   // 注册OnConnStart钩子函数的方法
-	CallOnConnStart(conn IConnection)       // 调用OnConnStart钩子函数的方法
-	CallOnConnStop(conn IConnection)        // 调用OnConnStart钩子函数的方法
+	Start() error                                      // 启动服务器，监听失败时返回错误
+	Stop()                                             // 停止服务器
+	Serve() error                                      // 运行服务器，阻塞直到服务器停止，启动失败时返回错误
+	AddRouter(msgId uint32, router IRouter)            // 给当前的服务注册一个Router，供客户端的连接处理使用
+	Use(middlewares ...Middleware)                     // 给当前的服务添加全局中间件
+	UseRouter(msgId uint32, middlewares ...Middleware) // 给当前的服务指定消息的Router添加中间件
+	GetConfig() *utils.GlobalObj                       // 获取当前Server使用的配置，重新加载配置之后会得到新的配置，不能修改
+	GetConnManager() IConnManager                      // 获取当前Server的连接管理模块
+	GetGroupManager() IGroupManager                    // 获取当前Server的连接分组管理模块
+	SetDataPack(dataPack IDataPack)                    // 设置当前Server的封包拆包模块，需要在Start之前调用
+	GetDataPack() IDataPack                            // 获取当前Server的封包拆包模块
+	SetRateLimiter(rateLimiter IRateLimiter)           // 设置当前Server的限流模块，为nil则不限流
+	GetRateLimiter() IRateLimiter                      // 获取当前Server的限流模块
+	SetOnConnStart(func(conn IConnection))             // 注册OnConnStart钩子函数的方法
+	SetOnConnStop(func(conn IConnection))              // 注册OnConnStart钩子函数的方法
+	CallOnConnStart(conn IConnection)                  // 调用OnConnStart钩子函数的方法
+	CallOnConnStop(conn IConnection)                   // 调用OnConnStart钩子函数的方法
+	SetOnConnDead(func(conn IConnection))              // 注册OnConnDead钩子函数的方法（连接空闲超时被认为失效时调用）
+	CallOnConnDead(conn IConnection)                   // 调用OnConnDead钩子函数的方法，没有注册时默认停止该连接
 }
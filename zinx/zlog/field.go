@@ -0,0 +1,52 @@
+package zlog
+
+import (
+	"fmt"
+	"net"
+)
+
+// Field 日志中的一个结构化字段
+type Field struct {
+	Key   string      // 字段名
+	Value interface{} // 字段值
+}
+
+// value 获取字段输出时的值，error和Stringer输出为字符串
+func (f Field) value() interface{} {
+	switch v := f.Value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+// Any 任意类型的字段
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// ConnID 连接ID字段
+func ConnID(connId uint32) Field {
+	return Field{Key: "connID", Value: connId}
+}
+
+// MsgID 消息ID字段
+func MsgID(msgId uint32) Field {
+	return Field{Key: "msgID", Value: msgId}
+}
+
+// RemoteAddr 远程地址字段
+func RemoteAddr(addr net.Addr) Field {
+	if addr == nil {
+		return Field{Key: "remoteAddr", Value: ""}
+	}
+	return Field{Key: "remoteAddr", Value: addr.String()}
+}
+
+// Err 错误字段
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
@@ -0,0 +1,302 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level 日志级别，低于当前级别的日志不会输出
+type Level int32
+
+const (
+	DebugLevel Level = iota // 调试信息，例如每个连接、每个消息的处理过程
+	InfoLevel               // 服务器运行状态的变化，例如启动、停止
+	WarnLevel               // 需要关注但不影响运行的情况，例如限流、发送队列已满
+	ErrorLevel              // 运行中发生的错误
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// ParseLevel 根据名称得到日志级别：debug、info、warn、error
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	default:
+		return InfoLevel, errors.New("unknown log level: " + name)
+	}
+}
+
+// core 同一个Logger及其通过With派生出的Logger共用的输出配置
+type core struct {
+	level int32      // 当前的日志级别，原子操作
+	json  int32      // 是否以JSON格式输出，原子操作
+	out   io.Writer  // 日志的输出
+	lock  sync.Mutex // 保证每条日志完整地写入输出
+}
+
+// Logger 带级别和结构化字段的日志模块
+type Logger struct {
+	core   *core   // 输出配置
+	fields []Field // 每条日志都会带上的字段
+}
+
+// New 创建一个输出到out的Logger，默认级别为info，以文本格式输出
+func New(out io.Writer) *Logger {
+	return &Logger{
+		core: &core{
+			level: int32(InfoLevel),
+			out:   out,
+		},
+	}
+}
+
+// With 派生一个每条日志都带上fields的Logger，与原Logger共用级别和输出
+func (l *Logger) With(fields ...Field) *Logger {
+	newFields := make([]Field, 0, len(l.fields)+len(fields))
+	newFields = append(newFields, l.fields...)
+	newFields = append(newFields, fields...)
+	return &Logger{
+		core:   l.core,
+		fields: newFields,
+	}
+}
+
+// SetLevel 设置日志级别
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.core.level, int32(level))
+}
+
+// GetLevel 获取日志级别
+func (l *Logger) GetLevel() Level {
+	return Level(atomic.LoadInt32(&l.core.level))
+}
+
+// Enabled 判断level级别的日志是否会输出，可以在构造开销较大的日志之前判断
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.GetLevel()
+}
+
+// SetJSON 设置是否以JSON格式输出，每行一个JSON对象
+func (l *Logger) SetJSON(enable bool) {
+	var v int32
+	if enable {
+		v = 1
+	}
+	atomic.StoreInt32(&l.core.json, v)
+}
+
+// SetOutput 设置日志的输出，返回原来的输出
+func (l *Logger) SetOutput(out io.Writer) io.Writer {
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+
+	old := l.core.out
+	l.core.out = out
+	return old
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.log(DebugLevel, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.log(InfoLevel, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.log(WarnLevel, msg, fields)
+}
+
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.log(ErrorLevel, msg, fields)
+}
+
+// log 将一条日志格式化之后写入输出
+func (l *Logger) log(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	now := time.Now()
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	if atomic.LoadInt32(&l.core.json) == 1 {
+		l.formatJSON(buf, now, level, msg, fields)
+	} else {
+		l.formatText(buf, now, level, msg, fields)
+	}
+
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	if _, err := l.core.out.Write(buf.Bytes()); err != nil {
+		fmt.Fprintln(os.Stderr, "zlog write error:", err)
+	}
+}
+
+// formatText 文本格式：时间 级别 消息 key=value ...
+func (l *Logger) formatText(buf *bytes.Buffer, now time.Time, level Level, msg string, fields []Field) {
+	buf.WriteString(now.Format("2006/01/02 15:04:05.000000"))
+	buf.WriteByte(' ')
+	buf.WriteString(strings.ToUpper(level.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+
+	writeField := func(field Field) {
+		buf.WriteByte(' ')
+		buf.WriteString(field.Key)
+		buf.WriteByte('=')
+		value := fmt.Sprint(field.value())
+		if value == "" || strings.ContainsAny(value, " =\"\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	for _, field := range l.fields {
+		writeField(field)
+	}
+	for _, field := range fields {
+		writeField(field)
+	}
+	buf.WriteByte('\n')
+}
+
+// formatJSON JSON格式：{"time":...,"level":...,"msg":...,key:value...}
+func (l *Logger) formatJSON(buf *bytes.Buffer, now time.Time, level Level, msg string, fields []Field) {
+	writeKV := func(key string, value interface{}) {
+		keyData, _ := json.Marshal(key)
+		valueData, err := json.Marshal(value)
+		if err != nil {
+			valueData, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buf.Write(keyData)
+		buf.WriteByte(':')
+		buf.Write(valueData)
+	}
+
+	buf.WriteByte('{')
+	writeKV("time", now.Format(time.RFC3339Nano))
+	buf.WriteByte(',')
+	writeKV("level", level.String())
+	buf.WriteByte(',')
+	writeKV("msg", msg)
+	for _, field := range l.fields {
+		buf.WriteByte(',')
+		writeKV(field.Key, field.value())
+	}
+	for _, field := range fields {
+		buf.WriteByte(',')
+		writeKV(field.Key, field.value())
+	}
+	buf.WriteString("}\n")
+}
+
+// std 默认的Logger，输出到标准输出
+var std = New(os.Stdout)
+
+// Default 获取默认的Logger
+func Default() *Logger {
+	return std
+}
+
+func With(fields ...Field) *Logger {
+	return std.With(fields...)
+}
+
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+func GetLevel() Level {
+	return std.GetLevel()
+}
+
+func Enabled(level Level) bool {
+	return std.Enabled(level)
+}
+
+func SetJSON(enable bool) {
+	std.SetJSON(enable)
+}
+
+func SetOutput(out io.Writer) io.Writer {
+	return std.SetOutput(out)
+}
+
+func Debug(msg string, fields ...Field) {
+	std.log(DebugLevel, msg, fields)
+}
+
+func Info(msg string, fields ...Field) {
+	std.log(InfoLevel, msg, fields)
+}
+
+func Warn(msg string, fields ...Field) {
+	std.log(WarnLevel, msg, fields)
+}
+
+func Error(msg string, fields ...Field) {
+	std.log(ErrorLevel, msg, fields)
+}
+
+var (
+	stdFile     *RotateFile // 默认的Logger当前输出的日志文件
+	stdFileLock sync.Mutex  // 保护stdFile的锁
+)
+
+// Setup 根据配置设置默认的Logger：级别、是否以JSON格式输出，以及输出的文件
+// file为空时输出到标准输出，maxSize为单个日志文件的最大MB数
+func Setup(level string, jsonMode bool, file string, maxSize int, maxBackups int) error {
+	lv, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	var newFile *RotateFile
+	if file != "" {
+		if newFile, err = NewRotateFile(file, int64(maxSize)*1024*1024, maxBackups); err != nil {
+			return err
+		}
+		out = newFile
+	}
+
+	stdFileLock.Lock()
+	defer stdFileLock.Unlock()
+
+	std.SetLevel(lv)
+	std.SetJSON(jsonMode)
+	std.SetOutput(out)
+	if stdFile != nil {
+		stdFile.Close()
+	}
+	stdFile = newFile
+	return nil
+}
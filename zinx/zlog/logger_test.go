@@ -0,0 +1,84 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 低于当前级别的日志不输出，字段以key=value的形式输出
+func TestLogger_Text(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	logger := New(buf)
+	logger.SetLevel(InfoLevel)
+
+	logger.Debug("hidden")
+	logger.With(ConnID(7)).Info("connection start", MsgID(3), Any("reason", "rate limit"))
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Error("debug log should not be written at info level")
+	}
+	if !strings.Contains(out, ` INFO connection start connID=7 msgID=3 reason="rate limit"`+"\n") {
+		t.Errorf("unexpected text log: %q", out)
+	}
+}
+
+// JSON模式每行输出一个JSON对象
+func TestLogger_JSON(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	logger := New(buf)
+	logger.SetJSON(true)
+
+	logger.Error("read msg error", ConnID(1), Err(errors.New("EOF")))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal("unmarshal json log error:", err, buf.String())
+	}
+	if entry["level"] != "error" || entry["msg"] != "read msg error" || entry["connID"] != float64(1) || entry["error"] != "EOF" {
+		t.Errorf("unexpected json log: %v", entry)
+	}
+}
+
+// 日志文件超过最大大小之后切割，只保留maxBackups个旧文件
+func TestRotateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "zinx.log")
+	file, err := NewRotateFile(path, 10, 2)
+	if err != nil {
+		t.Fatal("NewRotateFile error:", err)
+	}
+	defer file.Close()
+
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal("write error:", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "line4\n",
+		path + ".1": "line3\n",
+		path + ".2": "line2\n",
+	}
+	for name, content := range want {
+		data, err := ioutil.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("only 2 backups should be kept")
+	}
+}
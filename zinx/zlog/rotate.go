@@ -0,0 +1,103 @@
+package zlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotateFile 按大小切割的日志文件
+// 写入之后超过maxSize时，将当前文件重命名为path.1（原来的path.1重命名为path.2，以此类推），再创建新的文件
+type RotateFile struct {
+	path       string     // 日志文件的路径
+	maxSize    int64      // 单个日志文件的最大字节数，为0则不切割
+	maxBackups int        // 保留的旧日志文件数，超过的被删除
+	file       *os.File   // 当前的日志文件
+	size       int64      // 当前日志文件的字节数
+	lock       sync.Mutex // 保护当前日志文件的锁
+}
+
+// NewRotateFile 打开（不存在则创建）日志文件，需要时创建所在的目录
+func NewRotateFile(path string, maxSize int64, maxBackups int) (*RotateFile, error) {
+	r := &RotateFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open 以追加的方式打开日志文件
+func (r *RotateFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *RotateFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	// 当前文件写不下这条日志时先切割，保证每条日志都完整地在同一个文件中
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate 切割日志文件
+func (r *RotateFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	// 删除最旧的文件，其余的文件依次后移
+	if r.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotateFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package znet
+
+import (
+	"errors"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// packedMsg 广播给多个连接的消息，只封包一次，所有连接共享封包之后的数据
+// 协商了不同压缩算法的连接分别使用对应的压缩结果，每种压缩算法也只压缩、封包一次
+type packedMsg struct {
+	msgId  uint32
+	data   []byte
+	packed map[string][]byte // 封包之后的数据，key为压缩算法名称，不压缩时为空字符串
+}
+
+func newPackedMsg(msgId uint32, data []byte) *packedMsg {
+	return &packedMsg{
+		msgId:  msgId,
+		data:   data,
+		packed: make(map[string][]byte),
+	}
+}
+
+// pack 得到当前连接使用的封包数据，第一次使用某种压缩算法时进行压缩和封包
+func (p *packedMsg) pack(c *Connection) ([]byte, error) {
+	compressor := c.compression.get()
+	key := ""
+	if compressor != nil {
+		key = compressor.Name()
+	}
+
+	if binaryMsg, ok := p.packed[key]; ok {
+		return binaryMsg, nil
+	}
+	msg := compressMsg(compressor, NewMessage(p.msgId, p.data), c.Server.GetConfig().CompressThreshold)
+	binaryMsg, err := c.Server.GetDataPack().Pack(msg)
+	if err != nil {
+		return nil, err
+	}
+	p.packed[key] = binaryMsg
+	return binaryMsg, nil
+}
+
+// sendPacked 将已经封包的广播消息放入当前连接的发送队列，发送队列已满时按照SendBuffPolicy处理
+// 连接已经关闭时返回errConnClosed
+func (c *Connection) sendPacked(p *packedMsg) error {
+	c.closeLock.RLock()
+	isClosed := c.isClosed
+	c.closeLock.RUnlock()
+	if isClosed {
+		return errConnClosed
+	}
+
+	binaryMsg, err := p.pack(c)
+	if err != nil {
+		zlog.Error("Pack msg error", zlog.ConnID(c.ConnID), zlog.MsgID(p.msgId), zlog.Err(err))
+		return errors.New("pack msg error")
+	}
+	if err := c.pushBuffMsg(binaryMsg); err != nil {
+		return err
+	}
+	c.metrics.MsgSent(p.msgId, len(binaryMsg))
+	return nil
+}
+
+// errConnClosed 广播时连接已经关闭，这样的连接被跳过，不作为发送失败
+var errConnClosed = errors.New("connection closed when sending packed msg")
+
+// packedSender 可以直接发送已经封包的广播消息的连接
+type packedSender interface {
+	sendPacked(p *packedMsg) error
+}
+
+// sendTo 将广播消息发送给一个连接，不支持共享封包数据的连接（例如自定义的IConnection）使用SendBuffMsg发送
+func (p *packedMsg) sendTo(conn ziface.IConnection) error {
+	if sender, ok := conn.(packedSender); ok {
+		return sender.sendPacked(p)
+	}
+	return conn.SendBuffMsg(p.msgId, p.data)
+}
+
+// Broadcast 将消息发送给所有的连接，消息只封包一次，跳过已经关闭的连接
+// 返回发送失败的连接及其错误，全部成功时为nil
+func (cm *ConnManager) Broadcast(msgId uint32, data []byte) map[uint32]error {
+	cm.connLock.RLock()
+	conns := make([]ziface.IConnection, 0, len(cm.connections))
+	for _, conn := range cm.connections {
+		conns = append(conns, conn)
+	}
+	cm.connLock.RUnlock()
+
+	return multicast(conns, nil, msgId, data)
+}
+
+// Multicast 将消息发送给指定的连接，消息只封包一次，跳过已经关闭的连接
+// 返回发送失败（包括连接不存在）的连接及其错误，全部成功时为nil
+func (cm *ConnManager) Multicast(connIds []uint32, msgId uint32, data []byte) map[uint32]error {
+	var failures map[uint32]error
+	conns := make([]ziface.IConnection, 0, len(connIds))
+	cm.connLock.RLock()
+	for _, connId := range connIds {
+		if conn, ok := cm.connections[connId]; ok {
+			conns = append(conns, conn)
+		} else {
+			if failures == nil {
+				failures = make(map[uint32]error)
+			}
+			failures[connId] = errors.New("connection NOT FOUND")
+		}
+	}
+	cm.connLock.RUnlock()
+
+	return multicast(conns, failures, msgId, data)
+}
+
+// multicast 将消息依次放入每个连接的发送队列，发送失败的连接记录在failures中
+// 持有ConnManager的锁时不能发送，因为发送队列已满时连接可能断开并调用Remove
+func multicast(conns []ziface.IConnection, failures map[uint32]error, msgId uint32, data []byte) map[uint32]error {
+	p := newPackedMsg(msgId, data)
+	for _, conn := range conns {
+		err := p.sendTo(conn)
+		if err == nil || err == errConnClosed {
+			continue
+		}
+		if failures == nil {
+			failures = make(map[uint32]error)
+		}
+		failures[conn.GetConnID()] = err
+	}
+	return failures
+}
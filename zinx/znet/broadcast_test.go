@@ -0,0 +1,51 @@
+package znet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Multicast只封包一次，所有连接共享同一份数据，跳过已经关闭的连接，报告不存在的连接
+func TestConnManager_Multicast(t *testing.T) {
+	s := NewServer().(*Server)
+	newConn := func(connId uint32) *Connection {
+		c := newTestConn(s, connId)
+		s.GetConnManager().Add(c)
+		return c
+	}
+	conn1, conn2, closed, compressed := newConn(1), newConn(2), newConn(3), newConn(4)
+	closed.isClosed = true
+	compressed.compression.set(snappyCompressor{})
+
+	data := bytes.Repeat([]byte("sync players "), 100)
+	failures := s.GetConnManager().Multicast([]uint32{1, 2, 3, 4, 99}, 202, data)
+	if len(failures) != 1 || failures[99] == nil {
+		t.Errorf("failures = %v, want only connection 99", failures)
+	}
+
+	binary1, binary2 := <-conn1.msgBuffChan, <-conn2.msgBuffChan
+	if &binary1[0] != &binary2[0] {
+		t.Error("msg was packed more than once")
+	}
+	if len(closed.msgBuffChan) != 0 {
+		t.Error("closed connection received the msg")
+	}
+
+	// 协商了压缩算法的连接收到压缩之后的数据
+	msg, err := s.GetDataPack().ReadMsg(bytes.NewReader(<-compressed.msgBuffChan))
+	if err != nil {
+		t.Fatal("read compressed msg error:", err)
+	}
+	if err := compressed.compression.decompressMsg(msg, 0); err != nil || !bytes.Equal(msg.GetData(), data) {
+		t.Errorf("compressed msg = %d bytes, %v, want the original data", msg.GetDataLen(), err)
+	}
+
+	if failures := s.GetConnManager().Broadcast(1, []byte("hello")); failures != nil {
+		t.Errorf("broadcast failures = %v, want none", failures)
+	}
+	for _, c := range []*Connection{conn1, conn2, compressed} {
+		if len(c.msgBuffChan) != 1 {
+			t.Errorf("connection %d did not receive the broadcast", c.ConnID)
+		}
+	}
+}
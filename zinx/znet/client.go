@@ -0,0 +1,558 @@
+package znet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// TransportWebSocket 客户端通过WebSocket连接服务器（对应Server的WsPort）
+const TransportWebSocket = "websocket"
+
+// ErrCallTimeout Call在超时时间内没有收到服务器的回复
+var ErrCallTimeout = errors.New("call timeout")
+
+// Client IClient的接口实现，同时实现了IConnection，作为Router中Request所对应的连接
+// 可以用于机器人、压力测试以及服务器之间的连接
+type Client struct {
+	Name                string                                   // 客户端的名称
+	IP                  string                                   // 服务器的IP
+	Port                int                                      // 服务器的端口
+	Transport           string                                   // 连接服务器使用的传输协议：tcp、kcp、websocket
+	WsPath              string                                   // 服务器WebSocket监听的路径
+	TLSConfig           *tls.Config                              // 连接服务器使用的TLS配置，为nil则不使用TLS
+	DataPack            ziface.IDataPack                         // 封包拆包模块，需要与服务器一致
+	MsgHandler          ziface.IMsgHandler                       // 消息管理模块，用来绑定MsgID和对应的处理业务API关系
+	AutoReconnect       bool                                     // 连接断开或者连接失败之后是否自动重连
+	ReconnectMinBackoff time.Duration                            // 重连的最短等待时间
+	ReconnectMaxBackoff time.Duration                            // 重连的最长等待时间，每次连接失败等待时间翻倍，直到该值
+	HeartbeatInterval   time.Duration                            // 发送心跳消息的时间间隔，默认20秒（小于服务器默认的空闲超时），为0则不发送
+	OnConnect           func(conn ziface.IConnection)            // 每次连接上服务器之后自动调用的Hook函数
+	OnDisconnect        func(conn ziface.IConnection)            // 每次与服务器断开之后自动调用的Hook函数
+	OnServerError       func(conn ziface.IConnection, err error) // 收到服务器的错误消息（不是RPC的回复）时调用的Hook函数，err为*ServerError
+	Encryption          bool                                     // 是否在连接之后先与服务器进行密钥交换，此后的数据全部加密，需要服务器开启encryption
+	Compressions        []string                                 // 希望与服务器协商使用的压缩算法，按照优先级排列，为空则不协商
+	CompressThreshold   uint32                                   // 协商成功之后，消息内容达到该长度（字节）时才进行压缩
+	conn                net.Conn                                 // 当前与服务器的连接，没有连接时为nil
+	stream              net.Conn                                 // 读写数据使用的连接，开启会话加密之后为加密的连接
+	connLock            sync.RWMutex                             // 保护当前连接的锁
+	writeLock           sync.Mutex                               // 保证同一时刻只有一个goroutine写连接
+	exitChan            chan struct{}                            // 告知客户端已经停止的channel
+	stopOnce            sync.Once                                // 保证客户端只会被停止一次
+	lastActivity        int64                                    // 最后一次收到服务器数据的时间（UnixNano），原子操作
+	properties          map[string]interface{}                   // 连接属性集合
+	propertiesLock      sync.RWMutex                             // 保护连接属性的锁
+	seqGen              uint32                                   // 用来生成RPC请求序列号的计数器，原子操作
+	calls               map[uint32]chan ziface.IMessage          // 等待服务器回复的RPC请求，key为序列号
+	callsLock           sync.Mutex                               // 保护RPC请求集合及serverErr的锁
+	serverErr           error                                    // 当前连接收到的最后一个错误消息，连接断开时作为等待回复的Call的错误
+	compression         compression                              // 当前连接与服务器协商得到的压缩算法
+}
+
+// NewClient 初始化Client模块
+func NewClient(ip string, port int) ziface.IClient {
+	c := &Client{
+		Name:                "ZinxClient",
+		IP:                  ip,
+		Port:                port,
+		Transport:           TransportTCP,
+		WsPath:              "/",
+		DataPack:            NewDataPack(),
+		MsgHandler:          NewMsgHandler(),
+		AutoReconnect:       true,
+		ReconnectMinBackoff: time.Second,
+		ReconnectMaxBackoff: 30 * time.Second,
+		HeartbeatInterval:   20 * time.Second,
+		CompressThreshold:   256,
+		exitChan:            make(chan struct{}),
+		properties:          make(map[string]interface{}),
+		calls:               make(map[uint32]chan ziface.IMessage),
+	}
+	return c
+}
+
+// Start 启动客户端，在新的goroutine中连接服务器，连接断开之后按照退避时间自动重连
+func (c *Client) Start() {
+	zlog.Info("Zinx client is connecting", zlog.Any("client", c.Name), zlog.Any("ip", c.IP), zlog.Any("port", c.Port), zlog.Any("transport", c.Transport))
+	go c.run()
+}
+
+// Stop 停止客户端，断开与服务器的连接并且不再重连
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.exitChan)
+
+		c.connLock.RLock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.connLock.RUnlock()
+		zlog.Info("Zinx client stop", zlog.Any("client", c.Name))
+	})
+}
+
+// run 连接服务器并处理服务器的消息，连接断开之后自动重连
+func (c *Client) run() {
+	backoff := c.ReconnectMinBackoff
+	for {
+		select {
+		case <-c.exitChan:
+			return
+		default:
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			zlog.Warn("Zinx client dial error", zlog.Any("client", c.Name), zlog.Err(err))
+		} else {
+			// 连接成功之后重置等待时间
+			backoff = c.ReconnectMinBackoff
+			c.serve(conn)
+		}
+
+		if !c.AutoReconnect {
+			return
+		}
+
+		// 等待一段时间之后重连
+		select {
+		case <-c.exitChan:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > c.ReconnectMaxBackoff {
+			backoff = c.ReconnectMaxBackoff
+		}
+	}
+}
+
+// dial 根据传输协议连接服务器
+func (c *Client) dial() (net.Conn, error) {
+	address := net.JoinHostPort(c.IP, strconv.Itoa(c.Port))
+
+	switch c.Transport {
+	case TransportKCP:
+		return DialKCP(address)
+	case TransportWebSocket:
+		dialer := &websocket.Dialer{
+			HandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:  c.TLSConfig,
+		}
+		scheme := "ws"
+		if c.TLSConfig != nil {
+			scheme = "wss"
+		}
+		conn, _, err := dialer.Dial(scheme+"://"+address+c.WsPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		return newWsConn(conn), nil
+	case TransportTCP, "":
+		if c.TLSConfig != nil {
+			return tls.Dial("tcp", address, c.TLSConfig)
+		}
+		return net.Dial("tcp", address)
+	default:
+		return nil, errors.New("unsupported transport: " + c.Transport)
+	}
+}
+
+// serve 处理一次与服务器的连接，直到连接断开
+func (c *Client) serve(conn net.Conn) {
+	// 开启会话加密时先完成密钥交换
+	stream := conn
+	if c.Encryption {
+		var err error
+		if stream, err = clientHandshake(c.DataPack, conn); err != nil {
+			zlog.Warn("Zinx client key exchange error", zlog.Any("client", c.Name), zlog.Err(err))
+			conn.Close()
+			// 服务器在密钥交换之前拒绝了连接（例如连接数已满）
+			if serverErr, ok := err.(*ServerError); ok && c.OnServerError != nil {
+				c.OnServerError(c, serverErr)
+			}
+			return
+		}
+	}
+
+	c.connLock.Lock()
+	select {
+	case <-c.exitChan:
+		// 连接的过程中客户端已经停止
+		c.connLock.Unlock()
+		conn.Close()
+		return
+	default:
+		// 每次连接都需要重新协商压缩算法，协商完成之前不压缩
+		// 在发布新的连接之前重置，并发的发送不会使用上一个连接协商得到的压缩算法
+		c.compression.set(nil)
+		c.conn = conn
+		c.stream = stream
+	}
+	c.connLock.Unlock()
+	c.updateActivity()
+	c.callsLock.Lock()
+	c.serverErr = nil
+	c.callsLock.Unlock()
+	zlog.Info("Zinx client connected", zlog.Any("client", c.Name), zlog.RemoteAddr(conn.RemoteAddr()))
+
+	if len(c.Compressions) > 0 {
+		if err := c.SendMsg(CompressMsgID, []byte(strings.Join(c.Compressions, ","))); err != nil {
+			zlog.Warn("Zinx client send compress negotiation error", zlog.Any("client", c.Name), zlog.Err(err))
+		}
+	}
+
+	// 按照开发者传递进来的连接之后需要调用的处理业务，执行对应的Hook函数
+	if c.OnConnect != nil {
+		c.OnConnect(c)
+	}
+
+	// 定期发送心跳
+	heartbeatExit := make(chan struct{})
+	if c.HeartbeatInterval > 0 {
+		go c.startHeartbeat(heartbeatExit)
+	}
+
+	c.readLoop(stream)
+
+	close(heartbeatExit)
+	c.connLock.Lock()
+	c.conn = nil
+	c.stream = nil
+	c.connLock.Unlock()
+	conn.Close()
+	c.failCalls()
+	zlog.Info("Zinx client disconnected", zlog.Any("client", c.Name))
+
+	// 按照开发者传递进来的断开之后需要调用的处理业务，执行对应的Hook函数
+	if c.OnDisconnect != nil {
+		c.OnDisconnect(c)
+	}
+}
+
+// readLoop 不断读取服务器的消息，按顺序交给MsgHandler处理，直到连接断开
+func (c *Client) readLoop(conn net.Conn) {
+	for {
+		msg, err := c.DataPack.ReadMsg(conn)
+		if err != nil {
+			select {
+			case <-c.exitChan:
+			default:
+				zlog.Warn("Zinx client read msg error", zlog.Any("client", c.Name), zlog.Err(err))
+			}
+			return
+		}
+		c.updateActivity()
+
+		// 解压被压缩的消息内容
+		if err := c.compression.decompressMsg(msg, maxPackageSize(c.DataPack)); err != nil {
+			zlog.Warn("Zinx client decompress msg error", zlog.Any("client", c.Name), zlog.Err(err))
+			return
+		}
+
+		// 服务器回复的心跳不需要交给业务处理
+		if msg.GetMsgID() == HeartbeatMsgID {
+			continue
+		}
+
+		// 服务器回复了协商的压缩算法，此后发送的消息可以压缩
+		if msg.GetMsgID() == CompressMsgID {
+			c.handleCompress(msg)
+			continue
+		}
+
+		// RPC的回复交给等待的Call，没有对应的Call（例如已经超时）则交给Router处理
+		if msg.GetSeq() != 0 && c.deliverReply(msg) {
+			continue
+		}
+
+		// 服务器的错误消息不交给Router处理
+		if msg.GetMsgID() == ErrorMsgID {
+			c.handleServerError(newServerError(msg.GetData()))
+			continue
+		}
+
+		c.MsgHandler.DoMsgHandle(&Request{
+			conn: c,
+			msg:  msg,
+		})
+	}
+}
+
+// handleCompress 处理服务器回复的压缩协商结果，服务器选中的算法需要是客户端提出的算法
+func (c *Client) handleCompress(msg ziface.IMessage) {
+	name := string(msg.GetData())
+	if name == "" {
+		zlog.Info("Zinx client compression not accepted by server", zlog.Any("client", c.Name))
+		return
+	}
+	compressor, ok := selectCompressor(name, c.Compressions)
+	if !ok {
+		zlog.Warn("Zinx client unknown compressor from server", zlog.Any("client", c.Name), zlog.Any("compressor", name))
+		return
+	}
+	c.compression.set(compressor)
+}
+
+// handleServerError 处理服务器主动发送的错误消息（例如被踢出），通常服务器随后会断开连接
+func (c *Client) handleServerError(err *ServerError) {
+	zlog.Warn("Zinx client receive server error", zlog.Any("client", c.Name), zlog.Err(err))
+	c.callsLock.Lock()
+	c.serverErr = err
+	c.callsLock.Unlock()
+
+	if c.OnServerError != nil {
+		c.OnServerError(c, err)
+	}
+}
+
+// startHeartbeat 定期给服务器发送心跳消息，避免被服务器判定为空闲超时
+func (c *Client) startHeartbeat(exit chan struct{}) {
+	ticker := time.NewTicker(c.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.SendMsg(HeartbeatMsgID, nil); err != nil {
+				zlog.Warn("Zinx client send heartbeat error", zlog.Any("client", c.Name), zlog.Err(err))
+			}
+		case <-exit:
+			return
+		}
+	}
+}
+
+func (c *Client) AddRouter(msgId uint32, router ziface.IRouter) {
+	c.MsgHandler.AddRouter(msgId, router)
+}
+
+func (c *Client) Use(middlewares ...ziface.Middleware) {
+	c.MsgHandler.Use(middlewares...)
+}
+
+func (c *Client) UseRouter(msgId uint32, middlewares ...ziface.Middleware) {
+	c.MsgHandler.UseRouter(msgId, middlewares...)
+}
+
+func (c *Client) IsConnected() bool {
+	return c.GetConn() != nil
+}
+
+func (c *Client) GetConnection() ziface.IConnection {
+	return c
+}
+
+func (c *Client) SetDataPack(dataPack ziface.IDataPack) {
+	c.DataPack = dataPack
+}
+
+func (c *Client) SetOnConnect(hookFunc func(conn ziface.IConnection)) {
+	c.OnConnect = hookFunc
+}
+
+func (c *Client) SetOnDisconnect(hookFunc func(conn ziface.IConnection)) {
+	c.OnDisconnect = hookFunc
+}
+
+func (c *Client) SetOnServerError(hookFunc func(conn ziface.IConnection, err error)) {
+	c.OnServerError = hookFunc
+}
+
+// SendMsg 将要发送给服务器的数据进行封包，再直接写入连接
+func (c *Client) SendMsg(msgId uint32, data []byte) error {
+	return c.sendMsg(NewMessage(msgId, data))
+}
+
+// sendMsg 将消息进行封包，再直接写入连接
+func (c *Client) sendMsg(msg ziface.IMessage) error {
+	// 连接与压缩算法一起读取，保证使用的是当前连接协商得到的压缩算法
+	c.connLock.RLock()
+	conn := c.stream
+	compressor := c.compression.get()
+	c.connLock.RUnlock()
+	if conn == nil {
+		return errors.New("client not connected when sending msg")
+	}
+
+	// 进行封包，协商了压缩算法时先压缩较大的消息内容
+	msg = compressMsg(compressor, msg, c.CompressThreshold)
+	binaryMsg, err := c.DataPack.Pack(msg)
+	if err != nil {
+		zlog.Error("Pack msg error", zlog.MsgID(msg.GetMsgID()), zlog.Err(err))
+		return errors.New("pack msg error")
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err = conn.Write(binaryMsg)
+	return err
+}
+
+// SendSeqMsg 发送带序列号的消息，需要使用SeqDataPack这样会将序列号封包的封包拆包模块
+func (c *Client) SendSeqMsg(msgId uint32, seq uint32, data []byte) error {
+	msg := NewMessage(msgId, data)
+	msg.SetSeq(seq)
+	return c.sendMsg(msg)
+}
+
+// Call 发送一个RPC请求，阻塞等待服务器通过Request.Reply回复，超时返回ErrCallTimeout
+// 客户端和服务器都需要使用SeqDataPack这样会将序列号封包的封包拆包模块
+func (c *Client) Call(msgId uint32, data []byte, timeout time.Duration) ([]byte, error) {
+	// 生成序列号，0表示不是RPC消息，需要跳过
+	seq := atomic.AddUint32(&c.seqGen, 1)
+	if seq == 0 {
+		seq = atomic.AddUint32(&c.seqGen, 1)
+	}
+
+	replyChan := make(chan ziface.IMessage, 1)
+	c.callsLock.Lock()
+	c.calls[seq] = replyChan
+	c.callsLock.Unlock()
+	defer func() {
+		c.callsLock.Lock()
+		delete(c.calls, seq)
+		c.callsLock.Unlock()
+	}()
+
+	if err := c.SendSeqMsg(msgId, seq, data); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply, ok := <-replyChan:
+		if !ok {
+			// 连接断开之前收到了服务器的错误消息，返回该错误
+			c.callsLock.Lock()
+			err := c.serverErr
+			c.callsLock.Unlock()
+			if err == nil {
+				err = errors.New("connection closed before reply")
+			}
+			return nil, err
+		}
+		// 服务器拒绝了请求（例如服务器繁忙）
+		if reply.GetMsgID() == ErrorMsgID {
+			return nil, newServerError(reply.GetData())
+		}
+		return reply.GetData(), nil
+	case <-timer.C:
+		return nil, ErrCallTimeout
+	}
+}
+
+// deliverReply 将RPC的回复交给等待的Call，没有对应的Call时返回false
+func (c *Client) deliverReply(msg ziface.IMessage) bool {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	replyChan, ok := c.calls[msg.GetSeq()]
+	if !ok {
+		return false
+	}
+	delete(c.calls, msg.GetSeq())
+	replyChan <- msg
+	return true
+}
+
+// failCalls 连接断开，所有等待回复的Call立即返回错误
+func (c *Client) failCalls() {
+	c.callsLock.Lock()
+	defer c.callsLock.Unlock()
+
+	for seq, replyChan := range c.calls {
+		delete(c.calls, seq)
+		close(replyChan)
+	}
+}
+
+// SendBuffMsg 客户端直接写入连接，与SendMsg相同
+func (c *Client) SendBuffMsg(msgId uint32, data []byte) error {
+	return c.SendMsg(msgId, data)
+}
+
+func (c *Client) GetTCPConnection() *net.TCPConn {
+	tcpConn, _ := c.GetConn().(*net.TCPConn)
+	return tcpConn
+}
+
+func (c *Client) GetConn() net.Conn {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+
+	return c.conn
+}
+
+// GetPeerCertificate 获取服务器的证书
+func (c *Client) GetPeerCertificate() *x509.Certificate {
+	if conn := c.GetConn(); conn != nil {
+		return peerCertificate(conn)
+	}
+	return nil
+}
+
+// GetConnID 客户端只有一个连接，ConnID固定为0
+func (c *Client) GetConnID() uint32 {
+	return 0
+}
+
+func (c *Client) RemoteAddr() net.Addr {
+	if conn := c.GetConn(); conn != nil {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+func (c *Client) updateActivity() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+func (c *Client) GetLastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+}
+
+// GetRateLimitedCount 客户端不限流，始终返回0
+func (c *Client) GetRateLimitedCount() uint64 {
+	return 0
+}
+
+// Kick 服务器不接收客户端的错误消息，直接停止客户端
+func (c *Client) Kick(reason string) {
+	zlog.Info("Client kicked", zlog.Any("reason", reason))
+	c.Stop()
+}
+
+func (c *Client) SetProperty(key string, value interface{}) {
+	c.propertiesLock.Lock()
+	defer c.propertiesLock.Unlock()
+
+	c.properties[key] = value
+}
+
+func (c *Client) GetProperty(key string) (interface{}, error) {
+	c.propertiesLock.RLock()
+	defer c.propertiesLock.RUnlock()
+
+	if value, ok := c.properties[key]; ok {
+		return value, nil
+	} else {
+		return nil, errors.New("property NOT FOUND")
+	}
+}
+
+func (c *Client) RemoveProperty(key string) {
+	c.propertiesLock.Lock()
+	defer c.propertiesLock.Unlock()
+
+	delete(c.properties, key)
+}
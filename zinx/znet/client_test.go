@@ -0,0 +1,138 @@
+package znet
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// replyRouter 将收到的服务器回复转交给测试
+type replyRouter struct {
+	BaseRouter
+	replies chan string
+}
+
+func (r *replyRouter) Handle(request ziface.IRequest) {
+	r.replies <- string(request.GetData())
+}
+
+// 客户端按MsgID将服务器的回复交给对应的Router，服务器重启之后自动重连
+func TestClient_Reconnect(t *testing.T) {
+	newEchoServer := func(port int) *Server {
+		s := NewServer(WithAddress("127.0.0.1", port)).(*Server)
+		s.AddRouter(1, &echoRouter{})
+		if err := s.Start(); err != nil {
+			t.Fatal("Server start error:", err)
+		}
+		return s
+	}
+	port := freePort(t)
+	s := newEchoServer(port)
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.ReconnectMinBackoff = 20 * time.Millisecond
+	c.ReconnectMaxBackoff = 100 * time.Millisecond
+	router := &replyRouter{replies: make(chan string, 1)}
+	c.AddRouter(1, router)
+
+	connected := make(chan struct{}, 2)
+	var disconnectCount int32
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		connected <- struct{}{}
+	})
+	c.SetOnDisconnect(func(conn ziface.IConnection) {
+		atomic.AddInt32(&disconnectCount, 1)
+	})
+	c.Start()
+	defer c.Stop()
+
+	echo := func(data string) {
+		select {
+		case <-connected:
+		case <-time.After(2 * time.Second):
+			t.Fatal("client did not connect")
+		}
+		if err := c.SendMsg(1, []byte(data)); err != nil {
+			t.Fatal("Client send error:", err)
+		}
+		select {
+		case reply := <-router.replies:
+			if reply != data {
+				t.Errorf("reply = %s, want %s", reply, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("client did not receive reply")
+		}
+	}
+	echo("hello")
+
+	// 服务器重启，客户端断开之后自动重连
+	s.Stop()
+	s = newEchoServer(port)
+	defer s.Stop()
+	echo("hello again")
+
+	if n := atomic.LoadInt32(&disconnectCount); n != 1 {
+		t.Error("OnDisconnect called", n, "times, want 1")
+	}
+}
+
+// ackRouter 使用Reply回复RPC请求
+type ackRouter struct {
+	BaseRouter
+}
+
+func (r *ackRouter) Handle(request ziface.IRequest) {
+	request.Reply(append([]byte("ack:"), request.GetData()...))
+}
+
+// Call根据序列号得到对应的回复，服务器没有回复时超时返回
+func TestClient_Call(t *testing.T) {
+	port := freePort(t)
+	s := NewServer(WithAddress("127.0.0.1", port)).(*Server)
+	s.SetDataPack(NewSeqDataPack())
+	s.AddRouter(1, &ackRouter{})
+	s.AddRouter(2, &BaseRouter{})
+	s.Start()
+	defer s.Stop()
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.ReconnectMinBackoff = 20 * time.Millisecond
+	c.SetDataPack(NewSeqDataPack())
+	connected := make(chan struct{})
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		close(connected)
+	})
+	c.Start()
+	defer c.Stop()
+
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not connect")
+	}
+
+	// 并发的请求各自得到自己的回复
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(data string) {
+			reply, err := c.Call(1, []byte(data), time.Second)
+			if err == nil && string(reply) != "ack:"+data {
+				err = errors.New("reply = " + string(reply) + ", want ack:" + data)
+			}
+			errs <- err
+		}(strconv.Itoa(i))
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Error("Call error:", err)
+		}
+	}
+
+	if _, err := c.Call(2, nil, 50*time.Millisecond); err != ErrCallTimeout {
+		t.Error("Call without reply, want ErrCallTimeout, got", err)
+	}
+}
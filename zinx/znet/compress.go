@@ -0,0 +1,181 @@
+package znet
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"zinx/ziface"
+	"zinx/zlog"
+
+	"github.com/golang/snappy"
+)
+
+// CompressMsgID 框架保留的压缩协商消息ID，该消息不会交给MsgHandler处理
+// 客户端发送自己支持的压缩算法名称（按照优先级排列，逗号分隔），服务器回复选中的算法名称，为空表示不压缩
+// 协商成功之后双方发送的消息内容达到阈值时进行压缩
+const CompressMsgID uint32 = 0xFFFD
+
+// CompressFlag 消息内容被压缩时head中DataLen的最高位为1，其余的位为压缩之后的长度
+const CompressFlag uint32 = 0x80000000
+
+// CompressSnappy 内置的snappy压缩算法名称
+const CompressSnappy = "snappy"
+
+// Compressor 压缩算法
+type Compressor interface {
+	Name() string                                          // 算法名称，用于协商
+	Compress(data []byte) []byte                           // 压缩
+	Decompress(data []byte, maxLen uint32) ([]byte, error) // 解压，解压之后的长度超过maxLen时返回错误，maxLen为0则不限制
+}
+
+var (
+	compressors     = map[string]Compressor{CompressSnappy: snappyCompressor{}}
+	compressorsLock sync.RWMutex
+)
+
+// RegisterCompressor 注册一个压缩算法（例如zstd），需要客户端和服务器都注册才能协商使用
+func RegisterCompressor(compressor Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+
+	compressors[compressor.Name()] = compressor
+}
+
+// GetCompressor 根据名称获取已经注册的压缩算法
+func GetCompressor(name string) (Compressor, bool) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+
+	compressor, ok := compressors[name]
+	return compressor, ok
+}
+
+// snappyCompressor 使用snappy的压缩算法，速度快，适合频繁同步的游戏数据
+type snappyCompressor struct {
+}
+
+func (snappyCompressor) Name() string {
+	return CompressSnappy
+}
+
+func (snappyCompressor) Compress(data []byte) []byte {
+	return snappy.Encode(nil, data)
+}
+
+func (snappyCompressor) Decompress(data []byte, maxLen uint32) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	// 先检查解压之后的长度，避免恶意的数据占用大量内存
+	if maxLen > 0 && uint32(n) > maxLen {
+		return nil, errTooLargePackage
+	}
+	return snappy.Decode(nil, data)
+}
+
+// selectCompressor 从对方支持的压缩算法中选出第一个本地允许并且已经注册的算法
+func selectCompressor(offered string, allowed []string) (Compressor, bool) {
+	for _, name := range strings.Split(offered, ",") {
+		name = strings.TrimSpace(name)
+		for _, a := range allowed {
+			if name != a {
+				continue
+			}
+			if compressor, ok := GetCompressor(name); ok {
+				return compressor, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// compression 一个连接协商得到的压缩状态
+type compression struct {
+	compressor atomic.Value // 协商得到的压缩算法（compressorBox），没有协商时为空
+}
+
+// compressorBox atomic.Value要求每次保存的类型相同，因此将不同实现的Compressor包装为同一个类型
+type compressorBox struct {
+	Compressor
+}
+
+func (c *compression) get() Compressor {
+	box, _ := c.compressor.Load().(compressorBox)
+	return box.Compressor
+}
+
+func (c *compression) set(compressor Compressor) {
+	c.compressor.Store(compressorBox{compressor})
+}
+
+// compressMsg 协商了压缩算法并且消息内容达到阈值时压缩消息内容，并在DataLen中设置CompressFlag
+// 压缩之后没有变小则保持原样发送
+func (c *compression) compressMsg(msg ziface.IMessage, threshold uint32) ziface.IMessage {
+	return compressMsg(c.get(), msg, threshold)
+}
+
+// compressMsg 使用compressor压缩达到阈值的消息内容，compressor为nil时不压缩
+func compressMsg(compressor Compressor, msg ziface.IMessage, threshold uint32) ziface.IMessage {
+	if compressor == nil || msg.GetDataLen() < threshold || msg.GetDataLen() == 0 {
+		return msg
+	}
+	data := compressor.Compress(msg.GetData())
+	if len(data) >= len(msg.GetData()) {
+		return msg
+	}
+	compressed := NewMessage(msg.GetMsgID(), data)
+	compressed.SetSeq(msg.GetSeq())
+	compressed.SetDataLen(uint32(len(data)) | CompressFlag)
+	return compressed
+}
+
+// decompressMsg DataLen中设置了CompressFlag时解压消息内容，maxLen为解压之后允许的最大长度
+func (c *compression) decompressMsg(msg ziface.IMessage, maxLen uint32) error {
+	if msg.GetDataLen()&CompressFlag == 0 {
+		return nil
+	}
+	compressor := c.get()
+	if compressor == nil {
+		return errors.New("compressed message received before negotiation")
+	}
+	data, err := compressor.Decompress(msg.GetData(), maxLen)
+	if err != nil {
+		return err
+	}
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	return nil
+}
+
+// dataLen 去掉CompressFlag之后消息内容在数据流中的长度
+func dataLen(msg ziface.IMessage) uint32 {
+	return msg.GetDataLen() &^ CompressFlag
+}
+
+// maxPackageSize 获取封包拆包模块允许的最大包长度，不支持设置最大包长度时为0（不限制）
+func maxPackageSize(dp ziface.IDataPack) uint32 {
+	if limit, ok := dp.(interface{ GetMaxPackageSize() uint32 }); ok {
+		return limit.GetMaxPackageSize()
+	}
+	return 0
+}
+
+// handleCompress 处理客户端的压缩协商消息，在Reader中调用，保证协商完成之后才读取下一个消息
+func (c *Connection) handleCompress(msg ziface.IMessage) {
+	compressor, ok := selectCompressor(string(msg.GetData()), c.Server.GetConfig().Compressions)
+	name := ""
+	if ok {
+		name = compressor.Name()
+	}
+	// 先用未压缩的格式回复，回复交给Writer之后才开始压缩，保证客户端先收到回复
+	if err := c.SendMsg(CompressMsgID, []byte(name)); err != nil {
+		zlog.Debug("Reply compress negotiation error", zlog.ConnID(c.ConnID), zlog.Err(err))
+		return
+	}
+	if ok {
+		c.compression.set(compressor)
+		zlog.Debug("Compression negotiated", zlog.ConnID(c.ConnID), zlog.Any("compressor", name))
+	}
+}
@@ -0,0 +1,175 @@
+package znet
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// 压缩之后的消息经过各个封包拆包模块仍然能够还原，较小的消息不压缩
+func TestCompression_DataPack(t *testing.T) {
+	var comp compression
+	comp.set(snappyCompressor{})
+	data := bytes.Repeat([]byte("player position "), 100)
+
+	dataPacks := map[string]ziface.IDataPack{
+		"fixed":  NewDataPack(),
+		"seq":    NewSeqDataPack(),
+		"varint": NewVarintDataPack(),
+	}
+	for name, dp := range dataPacks {
+		msg := comp.compressMsg(NewMessage(202, data), 256)
+		if msg.GetDataLen()&CompressFlag == 0 || dataLen(msg) >= uint32(len(data)) {
+			t.Fatalf("%s: msg was not compressed, data len %d", name, dataLen(msg))
+		}
+		binaryMsg, err := dp.Pack(msg)
+		if err != nil {
+			t.Fatalf("%s: pack error: %v", name, err)
+		}
+
+		received, err := dp.ReadMsg(bytes.NewReader(binaryMsg))
+		if err != nil {
+			t.Fatalf("%s: read msg error: %v", name, err)
+		}
+		if err := comp.decompressMsg(received, maxPackageSize(dp)); err != nil {
+			t.Fatalf("%s: decompress error: %v", name, err)
+		}
+		if received.GetMsgID() != 202 || received.GetDataLen() != uint32(len(data)) || !bytes.Equal(received.GetData(), data) {
+			t.Errorf("%s: got msg %d with %d bytes, want the original msg", name, received.GetMsgID(), received.GetDataLen())
+		}
+	}
+
+	if msg := comp.compressMsg(NewMessage(1, []byte("small")), 256); msg.GetDataLen()&CompressFlag != 0 {
+		t.Error("msg below threshold was compressed")
+	}
+
+	// 解压之后超过最大包长度的消息被拒绝
+	msg := comp.compressMsg(NewMessage(202, data), 256)
+	if err := comp.decompressMsg(msg, 100); err == nil {
+		t.Error("decompressed msg over max package size was accepted")
+	}
+}
+
+// 客户端与服务器协商压缩算法之后，较大的消息在两个方向上都被压缩并且能够正确还原
+func TestClient_Compression(t *testing.T) {
+	port := freePort(t)
+	s := NewServer(WithAddress("127.0.0.1", port)).(*Server)
+	s.AddRouter(1, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.Compressions = []string{"unknown", CompressSnappy}
+	router := &replyRouter{replies: make(chan string, 1)}
+	c.AddRouter(1, router)
+	c.Start()
+	defer c.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.compression.get() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("compression was not negotiated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name := c.compression.get().Name(); name != CompressSnappy {
+		t.Fatalf("negotiated %s, want %s", name, CompressSnappy)
+	}
+
+	data := string(bytes.Repeat([]byte("sync players "), 200))
+	if err := c.SendMsg(1, []byte(data)); err != nil {
+		t.Fatal("Client send error:", err)
+	}
+	select {
+	case reply := <-router.replies:
+		if reply != data {
+			t.Errorf("reply has %d bytes, want %d", len(reply), len(data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive reply")
+	}
+}
+
+// 服务器重启之后客户端重新协商压缩算法，重连之后立即发送的消息不会使用上一个连接的压缩算法
+func TestClient_CompressionReconnect(t *testing.T) {
+	newServer := func(port int) *Server {
+		s := NewServer(WithAddress("127.0.0.1", port)).(*Server)
+		s.AddRouter(1, &echoRouter{})
+		s.AddRouter(2, &BaseRouter{})
+		if err := s.Start(); err != nil {
+			t.Fatal("Server start error:", err)
+		}
+		return s
+	}
+	port := freePort(t)
+	s := newServer(port)
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.Compressions = []string{CompressSnappy}
+	c.ReconnectMinBackoff = 20 * time.Millisecond
+	router := &replyRouter{replies: make(chan string, 1)}
+	c.AddRouter(1, router)
+	connected := make(chan struct{}, 2)
+	var disconnectCount int32
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		connected <- struct{}{}
+	})
+	c.SetOnDisconnect(func(conn ziface.IConnection) {
+		atomic.AddInt32(&disconnectCount, 1)
+	})
+	c.Start()
+	defer c.Stop()
+	<-connected
+	deadline := time.Now().Add(2 * time.Second)
+	for c.compression.get() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("compression was not negotiated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 一直发送较大的消息，覆盖重连之后、重新协商完成之前的时间
+	data := bytes.Repeat([]byte("sync players "), 200)
+	stopSending := make(chan struct{})
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		for {
+			select {
+			case <-stopSending:
+				return
+			default:
+			}
+			c.SendMsg(2, data)
+		}
+	}()
+
+	s.Stop()
+	s = newServer(port)
+	defer s.Stop()
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stopSending)
+	<-sendDone
+
+	if err := c.SendMsg(1, data); err != nil {
+		t.Fatal("Client send error:", err)
+	}
+	select {
+	case reply := <-router.replies:
+		if reply != string(data) {
+			t.Errorf("reply has %d bytes, want %d", len(reply), len(data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive reply after reconnect")
+	}
+	if n := atomic.LoadInt32(&disconnectCount); n != 1 {
+		t.Error("OnDisconnect called", n, "times, want 1")
+	}
+}
@@ -1,89 +1,150 @@
 package znet
 
 import (
+	"crypto/x509"
 	"errors"
-	"fmt"
-	"io"
 	"net"
 	"sync"
-	"zinx/utils"
+	"time"
 	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// 发送队列已满时的处理策略
+const (
+	SendBuffPolicyBlock      = "block"       // 阻塞等待发送队列有空位
+	SendBuffPolicyDropNewest = "drop_newest" // 丢弃当前要发送的消息
+	SendBuffPolicyDropOldest = "drop_oldest" // 丢弃发送队列中最早的消息
+	SendBuffPolicyDisconnect = "disconnect"  // 断开接收过慢的客户端
 )
 
 type Connection struct {
-	Server         ziface.IServer         // 当前Connection隶属于哪个Server
-	Conn           *net.TCPConn           // 当前连接的socket TCP套接字
-	ConnID         uint32                 // 当前连接的ID
-	isClosed       bool                   // 当前连接的状态
-	ExitChan       chan bool              // 告知当前连接已经退出（停止）的channel（由Reader告知Writer退出）
-	msgChan        chan []byte            // 无缓冲通道，用户读写goroutine之间的消息通信
-	MsgHandler     ziface.IMsgHandler     // 消息管理模块
-	properties     map[string]interface{} // 连接属性集合
-	propertiesLock sync.RWMutex           // 保护连接属性的锁
+	Server           ziface.IServer         // 当前Connection隶属于哪个Server
+	Conn             net.Conn               // 当前连接的socket套接字（TCP、WebSocket等）
+	stream           net.Conn               // Reader和Writer读写数据使用的连接，开启会话加密之后为加密的连接
+	ConnID           uint32                 // 当前连接的ID
+	isClosed         bool                   // 当前连接的状态
+	isStopping       bool                   // 当前连接是否已经开始停止（停止读取新的请求，等待已分发的请求处理完毕）
+	isStarted        bool                   // 是否已经调用了OnConnStart，密钥交换失败的连接不会调用OnConnStart和OnConnStop
+	closeLock        sync.RWMutex           // 保护连接状态的锁
+	ExitChan         chan bool              // 告知当前连接已经退出（停止）的channel（关闭时通知Writer及所有发送方退出）
+	stopChan         chan struct{}          // 告知当前连接已经开始停止的channel
+	readerExit       chan struct{}          // 告知Reader已经退出的channel
+	writerExit       chan struct{}          // 告知Writer已经退出的channel
+	msgChan          chan []byte            // 无缓冲通道，用户读写goroutine之间的消息通信
+	msgBuffChan      chan []byte            // 有缓冲通道，SendBuffMsg使用的发送队列
+	execChan         chan ziface.IRequest   // 没有开启Worker工作池时当前连接的串行执行队列，为nil则每个请求一个Goroutine
+	MsgHandler       ziface.IMsgHandler     // 消息管理模块
+	inflight         sync.WaitGroup         // 已经分发给MsgHandler但还没有处理完毕的请求
+	lastActivity     int64                  // 最后一次收到客户端数据的时间（UnixNano），原子操作
+	rateLimitedCount uint64                 // 因为超出限流而被处理的消息数，原子操作
+	compression      compression            // 与客户端协商得到的压缩算法
+	metrics          *Metrics               // 所属Server的统计模块，为nil则不统计
+	serverWg         *sync.WaitGroup        // 所属Server等待连接停止完毕的WaitGroup，为nil则不通知
+	properties       map[string]interface{} // 连接属性集合
+	propertiesLock   sync.RWMutex           // 保护连接属性的锁
 }
 
 // NewConnection 初始化链接模块
-func NewConnection(server ziface.IServer, conn *net.TCPConn, connID uint32, MsgHandler ziface.IMsgHandler) *Connection {
+func NewConnection(server ziface.IServer, conn net.Conn, connID uint32, MsgHandler ziface.IMsgHandler) *Connection {
 	connection := &Connection{
-		Server:     server,
-		Conn:       conn,
-		ConnID:     connID,
-		isClosed:   false,
-		MsgHandler: MsgHandler,
-		msgChan:    make(chan []byte),
-		ExitChan:   make(chan bool, 1),
-		properties: make(map[string]interface{}),
+		Server:      server,
+		Conn:        conn,
+		stream:      conn,
+		ConnID:      connID,
+		isClosed:    false,
+		MsgHandler:  MsgHandler,
+		msgChan:     make(chan []byte),
+		msgBuffChan: make(chan []byte, server.GetConfig().MaxMsgChanLen),
+		execChan:    newExecChan(server),
+		ExitChan:    make(chan bool),
+		stopChan:    make(chan struct{}),
+		readerExit:  make(chan struct{}),
+		writerExit:  make(chan struct{}),
+		properties:  make(map[string]interface{}),
+	}
+	connection.updateActivity()
+	if s, ok := server.(*Server); ok {
+		connection.metrics = s.Metrics
+		connection.serverWg = &s.connWg
+		s.connWg.Add(1)
 	}
 
 	// 将conn加入到ConnManager中
 	connection.Server.GetConnManager().Add(connection)
+	connection.metrics.ConnOpened()
 	return connection
 }
 
 // StartReader 连接的读数据业务方法
 func (c *Connection) StartReader() {
-	fmt.Println("[Reader goroutine is running]")
-	defer fmt.Println("ConnID =", c.ConnID, "RemoteAddr =", c.Conn.RemoteAddr().String(), "reader exit...")
+	zlog.Debug("Reader goroutine is running", zlog.ConnID(c.ConnID))
+	defer close(c.readerExit)
+	if c.execChan != nil {
+		// Reader是执行队列唯一的发送方，退出时关闭执行队列
+		defer close(c.execChan)
+	}
+	defer zlog.Debug("Reader exit", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()))
 	defer c.Stop()
 
+	dp := c.Server.GetDataPack()
 	for {
-		dp := NewDataPack()
-
-		// 读取客户端的msgHead，二进制流，8字节
-		headData := make([]byte, dp.GetHeadLen())
-		if _, err := io.ReadFull(c.Conn, headData); err != nil {
-			fmt.Println("Read msg head error:", err)
+		// 由封包拆包模块从连接的数据流中读取一个完整的消息
+		msg, err := dp.ReadMsg(c.stream)
+		if err != nil {
+			zlog.Debug("Read msg error", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()), zlog.Err(err))
+			if err == errTooLargePackage {
+				c.closeWithError(ErrCodePackageTooLarge, err.Error())
+			}
 			break
 		}
 
-		// 拆包，得到msgID和msgDataLen，存放在消息对象msg中
-		msg, err := dp.Unpack(headData)
-		if err != nil {
-			fmt.Println("Server unpack error:", err)
+		// 收到任何数据都说明客户端仍然存活
+		c.updateActivity()
+		c.metrics.MsgReceived(msg.GetMsgID(), int(dp.GetHeadLen()+dataLen(msg)))
+
+		// 解压被压缩的消息内容
+		if err := c.compression.decompressMsg(msg, maxPackageSize(dp)); err != nil {
+			zlog.Warn("Decompress msg error", zlog.ConnID(c.ConnID), zlog.MsgID(msg.GetMsgID()), zlog.Err(err))
+			if err == errTooLargePackage {
+				c.closeWithError(ErrCodePackageTooLarge, err.Error())
+			}
 			break
 		}
 
-		// 根据msgDataLen，存放在msg.Data中
-		var data []byte
-		if msg.GetDataLen() > 0 {
-			// 第二次读消息内容
-			data = make([]byte, msg.GetDataLen())
-			if _, err := io.ReadFull(c.Conn, data); err != nil {
-				fmt.Println("Server unpack error:", err)
-				break
-			}
+		// 心跳消息由框架直接回复，不交给MsgHandler处理
+		if msg.GetMsgID() == HeartbeatMsgID {
+			c.handleHeartbeat()
+			continue
+		}
+
+		// 压缩协商消息由框架直接处理
+		if msg.GetMsgID() == CompressMsgID {
+			c.handleCompress(msg)
+			continue
+		}
+
+		// 超出限流的消息按照策略进行处理
+		if !c.checkRateLimit(msg.GetMsgID()) {
+			continue
 		}
-		msg.SetData(data)
 
 		// 得到当前Conn的Request
+		c.inflight.Add(1)
 		req := &Request{
-			conn: c,
-			msg:  msg,
+			conn:   c,
+			msg:    msg,
+			onDone: c.inflight.Done,
 		}
 
-		if utils.GlobalObject.WorkerPoolSize > 0 {
-			// 已经开启了工作池，将消息发送给Worker工作池处理即可
-			c.MsgHandler.SendMsgToTaskQueue(req)
+		if c.Server.GetConfig().WorkerPoolSize > 0 {
+			// 已经开启了工作池，将消息发送给Worker工作池处理即可，消息队列已满时按照策略处理
+			if !c.sendToTaskQueue(req) {
+				req.done()
+			}
+		} else if c.execChan != nil {
+			// 交给当前连接的串行执行队列，保证同一个连接的请求按顺序处理
+			c.sendToExecutor(req)
 		} else {
 			// 从路由中，找到注册绑定的Conn对应的Router调用
 			go c.MsgHandler.DoMsgHandle(req)
@@ -93,67 +154,190 @@ func (c *Connection) StartReader() {
 
 // StartWriter 专门将数据发送给客户端
 func (c *Connection) StartWriter() {
-	fmt.Println("[Writer goroutine is running]")
-	defer fmt.Println("ConnID =", c.ConnID, "RemoteAddr =", c.Conn.RemoteAddr().String(), "writer exit...")
+	zlog.Debug("Writer goroutine is running", zlog.ConnID(c.ConnID))
+	defer close(c.writerExit)
+	defer zlog.Debug("Writer exit", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()))
 
 	// 不断地阻塞地等待channel的数据，如果有数据则发送给客户端
 	for {
 		select {
 		// 有数据要发送给客户端
 		case data := <-c.msgChan:
-			if _, err := c.Conn.Write(data); err != nil {
-				fmt.Println("Send data error:", err)
+			if _, err := c.stream.Write(data); err != nil {
+				zlog.Debug("Send data error", zlog.ConnID(c.ConnID), zlog.Err(err))
+				c.Stop()
+				return
+			}
+		// 发送队列中有数据要发送给客户端
+		case data := <-c.msgBuffChan:
+			if _, err := c.stream.Write(data); err != nil {
+				zlog.Debug("Send buff data error", zlog.ConnID(c.ConnID), zlog.Err(err))
+				c.Stop()
 				return
 			}
-		// 代表Reader已经退出，说明Writer也要退出
+		// 代表连接已经停止，将发送队列中剩余的数据发送完毕之后，Writer退出
 		case <-c.ExitChan:
+			c.flushBuffMsg()
+			return
+		}
+	}
+}
+
+// flushBuffMsg 将发送队列中剩余的数据发送给客户端，最长等待DrainTimeout
+func (c *Connection) flushBuffMsg() {
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Duration(c.Server.GetConfig().DrainTimeout) * time.Millisecond))
+	for {
+		select {
+		case data := <-c.msgBuffChan:
+			if _, err := c.stream.Write(data); err != nil {
+				zlog.Debug("Flush buff data error", zlog.ConnID(c.ConnID), zlog.Err(err))
+				return
+			}
+		default:
 			return
 		}
 	}
 }
 
 func (c *Connection) Start() {
-	fmt.Println("ConnID =", c.ConnID, "start...")
+	zlog.Debug("Connection start", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()))
+
+	// 开启会话加密时先完成密钥交换，失败则直接关闭连接
+	if c.Server.GetConfig().Encryption {
+		if err := c.startSession(); err != nil {
+			zlog.Warn("Key exchange error", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()), zlog.Err(err))
+			close(c.readerExit)
+			close(c.writerExit)
+			c.Stop()
+			return
+		}
+	}
 
-	// 启动从当前连接读数据的业务
-	go c.StartReader()
 	// 启动从当前连接写数据的业务
 	go c.StartWriter()
 
 	// 按照开发者传递进来的创建连接之后需要调用的处理业务，执行对应的Hook函数
+	// 在开始读取请求之前调用，保证业务处理时连接已经初始化完毕
+	c.isStarted = true
 	c.Server.CallOnConnStart(c)
+
+	// 启动当前连接的串行执行队列
+	if c.execChan != nil {
+		go c.startExecutor()
+	}
+	// 启动从当前连接读数据的业务
+	go c.StartReader()
+	// 启动空闲超时检测
+	go c.StartHeartbeatChecker()
 }
 
-func (c *Connection) Stop() {
-	fmt.Println("ConnID =", c.ConnID, "stop...")
+// stopped 当前连接是否已经开始停止
+func (c *Connection) stopped() bool {
+	select {
+	case <-c.stopChan:
+		return true
+	default:
+		return false
+	}
+}
 
-	// 如果当前连接已经关闭
-	if c.isClosed {
+// Stop 停止当前连接：先停止读取新的请求，再等待已分发的请求处理完毕（最长DrainTimeout），
+// 最后调用OnConnStop并回收资源。可以被多次调用，也可以在当前连接的业务处理中调用
+func (c *Connection) Stop() {
+	c.closeLock.Lock()
+	// 如果当前连接已经开始停止
+	if c.isStopping {
+		c.closeLock.Unlock()
 		return
 	}
+	c.isStopping = true
+	close(c.stopChan)
+	c.closeLock.Unlock()
+
+	zlog.Debug("Connection stop", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()))
+
+	// 让阻塞在读操作上的Reader立即返回，不再读取新的请求
+	c.Conn.SetReadDeadline(time.Now())
+
+	// 等待请求处理完毕的过程中，业务仍然可能调用Stop，因此异步地完成剩下的停止工作
+	go c.finishStop()
+}
+
+// finishStop 等待当前连接已分发的请求处理完毕，调用OnConnStop并回收资源
+func (c *Connection) finishStop() {
+	// 1、等待Reader退出，此后不会再有新的请求被分发
+	<-c.readerExit
+
+	// 2、等待已经分发的请求处理完毕
+	if !c.waitInflight(time.Duration(c.Server.GetConfig().DrainTimeout) * time.Millisecond) {
+		zlog.Warn("Drain timeout, some requests are still in progress", zlog.ConnID(c.ConnID))
+	}
+
+	// 3、按照开发者传递进来的销毁连接之前需要调用的处理业务，执行对应的Hook函数
+	// isStarted在Reader启动之前设置，Reader退出之后读取是安全的
+	if c.isStarted {
+		c.Server.CallOnConnStop(c)
+	}
+
+	// 4、标记连接已经关闭，告知Writer及所有正在发送数据的业务退出
+	c.closeLock.Lock()
 	c.isClosed = true
+	close(c.ExitChan)
+	c.closeLock.Unlock()
 
-	// 按照开发者传递进来的销毁连接之前需要调用的处理业务，执行对应的Hook函数
-	c.Server.CallOnConnStop(c)
+	// 等待Writer将发送队列中剩余的数据发送完毕
+	<-c.writerExit
 
 	// 关闭socket连接
 	c.Conn.Close()
 
-	// 告知Writer关闭
-	c.ExitChan <- true
+	// 清理当前连接的限流状态
+	if limiter := c.Server.GetRateLimiter(); limiter != nil {
+		limiter.RemoveConn(c.ConnID)
+	}
+
+	// 将当前连接移出全部分组，OnConnStop中仍然可以查询和使用当前连接所在的分组
+	c.Server.GetGroupManager().LeaveAll(c)
 
 	// 将当前连接从ConnManager中删除
 	c.Server.GetConnManager().Remove(c)
+	c.metrics.ConnClosed()
 
-	// 回收资源
-	close(c.ExitChan)
-	close(c.msgChan)
+	// 告知所属Server当前连接已经停止完毕
+	if c.serverWg != nil {
+		c.serverWg.Done()
+	}
+}
+
+// waitInflight 等待已经分发的请求处理完毕，超时返回false
+func (c *Connection) waitInflight(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
 
 func (c *Connection) GetTCPConnection() *net.TCPConn {
+	tcpConn, _ := c.Conn.(*net.TCPConn)
+	return tcpConn
+}
+
+func (c *Connection) GetConn() net.Conn {
 	return c.Conn
 }
 
+func (c *Connection) GetPeerCertificate() *x509.Certificate {
+	return peerCertificate(c.Conn)
+}
+
 func (c *Connection) GetConnID() uint32 {
 	return c.ConnID
 }
@@ -164,23 +348,119 @@ func (c *Connection) RemoteAddr() net.Addr {
 
 // SendMsg 将要发送给客户端的数据先进行封包，再发送
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
-	if c.isClosed {
+	return c.sendMsg(NewMessage(msgId, data))
+}
+
+// SendSeqMsg 发送带序列号的消息，用于回复客户端的RPC请求
+func (c *Connection) SendSeqMsg(msgId uint32, seq uint32, data []byte) error {
+	msg := NewMessage(msgId, data)
+	msg.SetSeq(seq)
+	return c.sendMsg(msg)
+}
+
+// sendMsg 将消息进行封包，再交给Writer发送给客户端
+func (c *Connection) sendMsg(msg ziface.IMessage) error {
+	c.closeLock.RLock()
+	isClosed := c.isClosed
+	c.closeLock.RUnlock()
+	if isClosed {
 		return errors.New("connection closed when sending msg")
 	}
 
-	// 进行封包
-	dp := NewDataPack()
-	binaryMsg, err := dp.Pack(NewMessage(msgId, data))
+	// 进行封包，协商了压缩算法时先压缩较大的消息内容
+	binaryMsg, err := c.packMsg(msg)
 	if err != nil {
-		fmt.Println("Pack ID =", msgId, "error")
+		zlog.Error("Pack msg error", zlog.ConnID(c.ConnID), zlog.MsgID(msg.GetMsgID()), zlog.Err(err))
 		return errors.New("pack msg error")
 	}
 
 	// 将数据发送给客户端
-	c.msgChan <- binaryMsg
+	select {
+	case c.msgChan <- binaryMsg:
+		c.metrics.MsgSent(msg.GetMsgID(), len(binaryMsg))
+		return nil
+	case <-c.ExitChan:
+		return errors.New("connection closed when sending msg")
+	}
+}
+
+// packMsg 将消息进行封包，协商了压缩算法并且消息内容达到CompressThreshold时先进行压缩
+func (c *Connection) packMsg(msg ziface.IMessage) ([]byte, error) {
+	msg = c.compression.compressMsg(msg, c.Server.GetConfig().CompressThreshold)
+	return c.Server.GetDataPack().Pack(msg)
+}
+
+// SendBuffMsg 将要发送给客户端的数据先进行封包，再放入带缓冲的发送队列，不等待Writer发送
+// 发送队列已满时按照SendBuffPolicy处理，避免一个慢速的客户端阻塞调用方
+func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
+	c.closeLock.RLock()
+	isClosed := c.isClosed
+	c.closeLock.RUnlock()
+	if isClosed {
+		return errors.New("connection closed when sending buff msg")
+	}
+
+	// 进行封包
+	binaryMsg, err := c.packMsg(NewMessage(msgId, data))
+	if err != nil {
+		zlog.Error("Pack msg error", zlog.ConnID(c.ConnID), zlog.MsgID(msgId), zlog.Err(err))
+		return errors.New("pack msg error")
+	}
+
+	if err := c.pushBuffMsg(binaryMsg); err != nil {
+		return err
+	}
+	c.metrics.MsgSent(msgId, len(binaryMsg))
 	return nil
 }
 
+// pushBuffMsg 将封包之后的数据放入发送队列，发送队列已满时按照SendBuffPolicy处理
+func (c *Connection) pushBuffMsg(binaryMsg []byte) error {
+	// 发送队列未满时直接放入队列
+	select {
+	case c.msgBuffChan <- binaryMsg:
+		return nil
+	case <-c.ExitChan:
+		return errors.New("connection closed when sending buff msg")
+	default:
+	}
+
+	// 发送队列已满，根据策略进行处理
+	switch c.Server.GetConfig().SendBuffPolicy {
+	case SendBuffPolicyDropNewest:
+		// 丢弃当前要发送的消息
+		return errors.New("send buff full, msg dropped")
+	case SendBuffPolicyDropOldest:
+		// 丢弃发送队列中最早的消息，为当前消息腾出位置
+		for {
+			select {
+			case c.msgBuffChan <- binaryMsg:
+				return nil
+			case <-c.ExitChan:
+				return errors.New("connection closed when sending buff msg")
+			default:
+			}
+			select {
+			case <-c.msgBuffChan:
+			default:
+			}
+		}
+	case SendBuffPolicyDisconnect:
+		// 客户端接收得太慢，断开连接
+		zlog.Warn("Send buff full, disconnect slow client", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()))
+		c.Stop()
+		return errors.New("send buff full, connection stopped")
+	default:
+		// 阻塞等待发送队列有空位
+		select {
+		case c.msgBuffChan <- binaryMsg:
+			return nil
+		case <-c.ExitChan:
+			return errors.New("connection closed when sending buff msg")
+		}
+	}
+}
+
 func (c *Connection) SetProperty(key string, value interface{}) {
 	c.propertiesLock.Lock()
 	defer c.propertiesLock.Unlock()
@@ -2,9 +2,9 @@ package znet
 
 import (
 	"errors"
-	"fmt"
 	"sync"
 	"zinx/ziface"
+	"zinx/zlog"
 )
 
 // ConnManager 连接管理模块
@@ -26,7 +26,7 @@ func (cm *ConnManager) Add(conn ziface.IConnection) {
 
 	// 将conn加入到ConnManager中
 	cm.connections[conn.GetConnID()] = conn
-	fmt.Println("Add ConnID =", conn.GetConnID(), "to ConnManager success, conn num =", cm.Len())
+	zlog.Debug("Add connection to ConnManager", zlog.ConnID(conn.GetConnID()), zlog.Any("connNum", len(cm.connections)))
 }
 
 func (cm *ConnManager) Remove(conn ziface.IConnection) {
@@ -34,8 +34,11 @@ func (cm *ConnManager) Remove(conn ziface.IConnection) {
 	cm.connLock.Lock()
 	defer cm.connLock.Unlock()
 
+	if _, ok := cm.connections[conn.GetConnID()]; !ok {
+		return
+	}
 	delete(cm.connections, conn.GetConnID())
-	fmt.Println("Remove ConnID =", conn.GetConnID(), "to ConnManager success, conn num =", cm.Len())
+	zlog.Debug("Remove connection from ConnManager", zlog.ConnID(conn.GetConnID()), zlog.Any("connNum", len(cm.connections)))
 }
 
 func (cm *ConnManager) Get(connId uint32) (ziface.IConnection, error) {
@@ -51,20 +54,37 @@ func (cm *ConnManager) Get(connId uint32) (ziface.IConnection, error) {
 }
 
 func (cm *ConnManager) Len() int {
+	// 保护共享资源，加读锁
+	cm.connLock.RLock()
+	defer cm.connLock.RUnlock()
+
 	return len(cm.connections)
 }
 
+// Clear 停止所有连接，每个连接在处理完已分发的请求之后会将自己从ConnManager中删除
 func (cm *ConnManager) Clear() {
-	// 保护共享资源，加写锁
-	cm.connLock.Lock()
-	defer cm.connLock.Unlock()
+	// 保护共享资源，加读锁，先取出全部连接
+	// 不能在持有锁的同时停止连接，因为连接停止时会调用Remove
+	cm.connLock.RLock()
+	conns := make([]ziface.IConnection, 0, len(cm.connections))
+	for _, conn := range cm.connections {
+		conns = append(conns, conn)
+	}
+	cm.connLock.RUnlock()
 
-	// 删除conn并停止conn的工作
-	for connID, conn := range cm.connections {
-		// 停止
+	// 停止conn的工作
+	for _, conn := range conns {
 		conn.Stop()
-		// 删除
-		delete(cm.connections, connID)
 	}
-	fmt.Println("Clear all connections success, conn num =", cm.Len())
+	zlog.Info("Clear all connections", zlog.Any("connNum", len(conns)))
+}
+
+// Kick 给指定的连接发送被踢出的错误消息，再停止该连接
+func (cm *ConnManager) Kick(connId uint32, reason string) error {
+	conn, err := cm.Get(connId)
+	if err != nil {
+		return err
+	}
+	conn.Kick(reason)
+	return nil
 }
@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
+	"sync/atomic"
 	"zinx/utils"
 	"zinx/ziface"
 )
 
 // DataPack 封包拆包的具体模块
+// head为DataLen uint32 + ID uint32，默认使用小端字节序，DataLen的最高位为压缩标志（CompressFlag）
 type DataPack struct {
+	packageSizeLimit
+	order binary.ByteOrder // head使用的字节序
 }
 
 func (d *DataPack) GetHeadLen() uint32 {
@@ -17,23 +22,31 @@ func (d *DataPack) GetHeadLen() uint32 {
 	return 8
 }
 
+// byteOrder 获取head使用的字节序，没有设置时为小端字节序
+func (d *DataPack) byteOrder() binary.ByteOrder {
+	if d.order == nil {
+		return binary.LittleEndian
+	}
+	return d.order
+}
+
 // Pack 封包
 func (d *DataPack) Pack(message ziface.IMessage) ([]byte, error) {
 	// 创建一个存放字节的缓冲
 	dataBuff := bytes.NewBuffer([]byte{})
 
 	// 将DataLen写入dataBuff中
-	if err := binary.Write(dataBuff, binary.LittleEndian, message.GetDataLen()); err != nil {
+	if err := binary.Write(dataBuff, d.byteOrder(), message.GetDataLen()); err != nil {
 		return nil, err
 	}
 
 	// 将ID写入dataBuff中
-	if err := binary.Write(dataBuff, binary.LittleEndian, message.GetMsgID()); err != nil {
+	if err := binary.Write(dataBuff, d.byteOrder(), message.GetMsgID()); err != nil {
 		return nil, err
 	}
 
 	// 将Data数据写入dataBuff中
-	if err := binary.Write(dataBuff, binary.LittleEndian, message.GetData()); err != nil {
+	if err := binary.Write(dataBuff, d.byteOrder(), message.GetData()); err != nil {
 		return nil, err
 	}
 	return dataBuff.Bytes(), nil
@@ -48,22 +61,100 @@ func (d *DataPack) Unpack(data []byte) (ziface.IMessage, error) {
 	message := &Message{}
 
 	// 读DataLen
-	if err := binary.Read(dataBuff, binary.LittleEndian, &message.DataLen); err != nil {
+	if err := binary.Read(dataBuff, d.byteOrder(), &message.DataLen); err != nil {
 		return nil, err
 	}
 	// 读ID
-	if err := binary.Read(dataBuff, binary.LittleEndian, &message.ID); err != nil {
+	if err := binary.Read(dataBuff, d.byteOrder(), &message.ID); err != nil {
 		return nil, err
 	}
 
 	// 判断DataLen是否已经超出了允许的最大包长度
-	if utils.GlobalObject.MaxPackageSize > 0 && message.DataLen > utils.GlobalObject.MaxPackageSize {
-		return nil, errors.New("too large message data received")
+	if err := d.checkPackageSize(message.DataLen); err != nil {
+		return nil, err
 	}
 	return message, nil
 }
 
-// NewDataPack 初始化方法
+// ReadMsg 从数据流中读取一个完整的消息
+func (d *DataPack) ReadMsg(reader io.Reader) (ziface.IMessage, error) {
+	return readFixedHeadMsg(d, reader)
+}
+
+// NewDataPack 初始化方法，head使用小端字节序
 func NewDataPack() *DataPack {
-	return &DataPack{}
+	return &DataPack{
+		packageSizeLimit: newPackageSizeLimit(),
+		order:            binary.LittleEndian,
+	}
+}
+
+// NewBigEndianDataPack 初始化方法，head使用大端字节序
+func NewBigEndianDataPack() *DataPack {
+	return &DataPack{
+		packageSizeLimit: newPackageSizeLimit(),
+		order:            binary.BigEndian,
+	}
+}
+
+// packageSizeLimit 封包拆包模块允许的最大包长度，Server会按照自己的配置进行设置
+type packageSizeLimit struct {
+	maxPackageSize uint32 // 允许的最大包长度，为0则不限制，原子操作
+}
+
+// newPackageSizeLimit 使用默认的最大包长度
+func newPackageSizeLimit() packageSizeLimit {
+	return packageSizeLimit{maxPackageSize: utils.DefaultMaxPackageSize}
+}
+
+// SetMaxPackageSize 设置允许的最大包长度，为0则不限制
+func (l *packageSizeLimit) SetMaxPackageSize(size uint32) {
+	atomic.StoreUint32(&l.maxPackageSize, size)
+}
+
+// GetMaxPackageSize 获取允许的最大包长度
+func (l *packageSizeLimit) GetMaxPackageSize() uint32 {
+	return atomic.LoadUint32(&l.maxPackageSize)
+}
+
+// checkPackageSize 判断消息内容的长度是否已经超出了允许的最大包长度，不包括DataLen中的CompressFlag
+func (l *packageSizeLimit) checkPackageSize(dataLen uint32) error {
+	if max := l.GetMaxPackageSize(); max > 0 && dataLen&^CompressFlag > max {
+		return errTooLargePackage
+	}
+	return nil
+}
+
+// errTooLargePackage 消息内容的长度超出了允许的最大包长度，Reader收到之后给客户端发送错误消息再断开连接
+var errTooLargePackage = errors.New("too large message data received")
+
+// maxPackageSizeSetter 可以设置最大包长度的封包拆包模块
+type maxPackageSizeSetter interface {
+	SetMaxPackageSize(size uint32)
+}
+
+// readFixedHeadMsg 适用于head长度固定的封包拆包模块，从数据流中读取一个完整的消息
+func readFixedHeadMsg(dp ziface.IDataPack, reader io.Reader) (ziface.IMessage, error) {
+	// 第一次读head
+	headData := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(reader, headData); err != nil {
+		return nil, err
+	}
+
+	// 拆包，得到msgID和msgDataLen，存放在消息对象msg中
+	msg, err := dp.Unpack(headData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据msgDataLen，第二次读消息内容
+	var data []byte
+	if dataLen(msg) > 0 {
+		data = make([]byte, dataLen(msg))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+	}
+	msg.SetData(data)
+	return msg, nil
 }
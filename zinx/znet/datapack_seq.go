@@ -0,0 +1,62 @@
+package znet
+
+import (
+	"encoding/binary"
+	"io"
+	"zinx/ziface"
+)
+
+// SeqDataPack 带序列号的封包拆包模块，用于请求/回复形式的RPC
+// head为DataLen uint32 + ID uint32 + Seq uint32，使用小端字节序
+type SeqDataPack struct {
+	packageSizeLimit
+}
+
+func (d *SeqDataPack) GetHeadLen() uint32 {
+	// DataLen uint32（4字节）+ ID uint32（4字节）+ Seq uint32（4字节）
+	return 12
+}
+
+// Pack 封包
+func (d *SeqDataPack) Pack(message ziface.IMessage) ([]byte, error) {
+	data := make([]byte, d.GetHeadLen()+uint32(len(message.GetData())))
+	// 写DataLen
+	binary.LittleEndian.PutUint32(data[0:], message.GetDataLen())
+	// 写ID
+	binary.LittleEndian.PutUint32(data[4:], message.GetMsgID())
+	// 写Seq
+	binary.LittleEndian.PutUint32(data[8:], message.GetSeq())
+	// 写Data
+	copy(data[d.GetHeadLen():], message.GetData())
+	return data, nil
+}
+
+// Unpack 拆包，只需要将head信息读取出来，再根据head信息中消息内容的长度进行一次读
+func (d *SeqDataPack) Unpack(data []byte) (ziface.IMessage, error) {
+	if uint32(len(data)) < d.GetHeadLen() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	message := &Message{
+		DataLen: binary.LittleEndian.Uint32(data[0:]),
+		ID:      binary.LittleEndian.Uint32(data[4:]),
+		Seq:     binary.LittleEndian.Uint32(data[8:]),
+	}
+
+	// 判断DataLen是否已经超出了允许的最大包长度
+	if err := d.checkPackageSize(message.DataLen); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ReadMsg 从数据流中读取一个完整的消息
+func (d *SeqDataPack) ReadMsg(reader io.Reader) (ziface.IMessage, error) {
+	return readFixedHeadMsg(d, reader)
+}
+
+// NewSeqDataPack 初始化方法
+func NewSeqDataPack() *SeqDataPack {
+	return &SeqDataPack{
+		packageSizeLimit: newPackageSizeLimit(),
+	}
+}
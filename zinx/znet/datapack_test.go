@@ -1,10 +1,13 @@
 package znet
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"testing"
+	"time"
+	"zinx/ziface"
 )
 
 // 只负责DataPack封包拆包的单元测试
@@ -14,13 +17,18 @@ func TestDataPack(t *testing.T) {
 		fmt.Println("Server listen err:", err)
 		return
 	}
+	defer listener.Close()
+
+	// 服务端完整读取到的消息
+	received := make(chan *Message, 3)
+
 	// 创建一个goroutine承载负责从客户端处理业务
 	go func() {
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
 				fmt.Println("Server accept err:", err)
-				continue
+				return
 			}
 			go func(conn net.Conn) {
 				// 处理客户端的请求，拆包的过程
@@ -51,6 +59,7 @@ func TestDataPack(t *testing.T) {
 
 						// 完整的一个消息已经读取完毕
 						fmt.Println("Receive ID =", msg.ID, "DataLen =", msg.DataLen, "Data =", string(msg.Data))
+						received <- msg
 					}
 				}
 			}(conn)
@@ -62,6 +71,7 @@ func TestDataPack(t *testing.T) {
 		fmt.Println("Client dial error:", err)
 		return
 	}
+	defer conn.Close()
 
 	dp := NewDataPack()
 
@@ -105,6 +115,76 @@ func TestDataPack(t *testing.T) {
 	// 一次性发给服务器
 	conn.Write(sendData1)
 
-	// 客户端阻塞
-	select {}
+	// 服务端应该按顺序拆出三个完整的消息
+	for _, want := range []*Message{msg1, msg2, msg3} {
+		select {
+		case got := <-received:
+			if got.ID != want.ID || string(got.Data) != string(want.Data) {
+				t.Errorf("Receive ID = %d Data = %s, want ID = %d Data = %s", got.ID, got.Data, want.ID, want.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Receive msg timeout")
+		}
+	}
+}
+
+// 不同的封包拆包模块都可以从粘在一起的数据流中拆出完整的消息
+func TestDataPack_ReadMsg(t *testing.T) {
+	dataPacks := map[string]ziface.IDataPack{
+		"little endian": NewDataPack(),
+		"big endian":    NewBigEndianDataPack(),
+		"varint":        NewVarintDataPack(),
+		"seq":           NewSeqDataPack(),
+	}
+	msgs := []*Message{
+		NewMessage(1, []byte("zinx")),
+		NewMessage(2, nil),
+		NewMessage(HeartbeatMsgID, []byte("hello, zinx")),
+		NewMessage(300, make([]byte, 200)),
+	}
+
+	for name, dp := range dataPacks {
+		stream := bytes.NewBuffer(nil)
+		for _, msg := range msgs {
+			data, err := dp.Pack(msg)
+			if err != nil {
+				t.Fatal(name, "pack error:", err)
+			}
+			stream.Write(data)
+		}
+
+		for _, want := range msgs {
+			got, err := dp.ReadMsg(stream)
+			if err != nil {
+				t.Fatal(name, "read msg error:", err)
+			}
+			if got.GetMsgID() != want.ID || got.GetDataLen() != want.DataLen || !bytes.Equal(got.GetData(), want.Data) {
+				t.Errorf("%s: got ID = %d DataLen = %d, want ID = %d DataLen = %d",
+					name, got.GetMsgID(), got.GetDataLen(), want.ID, want.DataLen)
+			}
+		}
+		if _, err := dp.ReadMsg(stream); err != io.EOF {
+			t.Error(name, "read from empty stream, want io.EOF, got", err)
+		}
+	}
+
+	// 大端字节序和小端字节序的head不同
+	little, _ := NewDataPack().Pack(msgs[0])
+	big, _ := NewBigEndianDataPack().Pack(msgs[0])
+	if bytes.Equal(little, big) {
+		t.Error("big endian head should differ from little endian head")
+	}
+
+	// SeqDataPack会将序列号封包
+	seqMsg := NewMessage(1, []byte("zinx"))
+	seqMsg.SetSeq(42)
+	data, _ := NewSeqDataPack().Pack(seqMsg)
+	if got, err := NewSeqDataPack().ReadMsg(bytes.NewReader(data)); err != nil || got.GetSeq() != 42 {
+		t.Error("seq data pack should keep seq 42, got", got, err)
+	}
+
+	// VarintDataPack的消息ID只有16位
+	if _, err := NewVarintDataPack().Pack(NewMessage(0x10000, nil)); err == nil {
+		t.Error("varint data pack should reject msg id > 0xFFFF")
+	}
 }
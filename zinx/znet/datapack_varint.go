@@ -0,0 +1,111 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"zinx/ziface"
+)
+
+// VarintDataPack 使用变长head的封包拆包模块
+// head为varint编码的DataLen + 大端字节序的ID uint16，适合消息ID较少、消息较小的客户端
+type VarintDataPack struct {
+	packageSizeLimit
+}
+
+// varintMaxMsgID VarintDataPack允许的最大消息ID
+const varintMaxMsgID = 0xFFFF
+
+func (d *VarintDataPack) GetHeadLen() uint32 {
+	// head长度可变，返回最大长度：DataLen varint（最多5字节）+ ID uint16（2字节）
+	return binary.MaxVarintLen32 + 2
+}
+
+// Pack 封包
+func (d *VarintDataPack) Pack(message ziface.IMessage) ([]byte, error) {
+	if message.GetMsgID() > varintMaxMsgID {
+		return nil, errors.New("msg id out of range for varint data pack")
+	}
+
+	data := make([]byte, binary.MaxVarintLen32+2+len(message.GetData()))
+	// 写DataLen
+	n := binary.PutUvarint(data, uint64(message.GetDataLen()))
+	// 写ID
+	binary.BigEndian.PutUint16(data[n:], uint16(message.GetMsgID()))
+	n += 2
+	// 写Data
+	n += copy(data[n:], message.GetData())
+	return data[:n], nil
+}
+
+// Unpack 拆包，data中需要包含完整的head
+func (d *VarintDataPack) Unpack(data []byte) (ziface.IMessage, error) {
+	// 读DataLen
+	dataLen, n := binary.Uvarint(data)
+	if n <= 0 || dataLen > 0xFFFFFFFF {
+		return nil, errors.New("invalid varint data len")
+	}
+	// 读ID
+	if len(data) < n+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	message := &Message{
+		ID:      uint32(binary.BigEndian.Uint16(data[n:])),
+		DataLen: uint32(dataLen),
+	}
+
+	// 判断DataLen是否已经超出了允许的最大包长度
+	if err := d.checkPackageSize(message.DataLen); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// ReadMsg 从数据流中读取一个完整的消息，head的长度可变，需要逐字节读取DataLen
+func (d *VarintDataPack) ReadMsg(reader io.Reader) (ziface.IMessage, error) {
+	// 第一次读head：逐字节读取varint编码的DataLen，再读取2字节的ID
+	headData := make([]byte, 0, d.GetHeadLen())
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(reader, b); err != nil {
+			return nil, err
+		}
+		headData = append(headData, b[0])
+		// 最高位为0代表varint结束
+		if b[0] < 0x80 {
+			break
+		}
+		if len(headData) >= binary.MaxVarintLen32 {
+			return nil, errors.New("invalid varint data len")
+		}
+	}
+	idData := make([]byte, 2)
+	if _, err := io.ReadFull(reader, idData); err != nil {
+		return nil, err
+	}
+	headData = append(headData, idData...)
+
+	// 拆包，得到msgID和msgDataLen
+	msg, err := d.Unpack(headData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据msgDataLen，第二次读消息内容
+	var data []byte
+	if dataLen(msg) > 0 {
+		data = make([]byte, dataLen(msg))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+	}
+	msg.SetData(data)
+	return msg, nil
+}
+
+// NewVarintDataPack 初始化方法
+func NewVarintDataPack() *VarintDataPack {
+	return &VarintDataPack{
+		packageSizeLimit: newPackageSizeLimit(),
+	}
+}
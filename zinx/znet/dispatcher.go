@@ -0,0 +1,98 @@
+package znet
+
+import (
+	"sync/atomic"
+	"zinx/ziface"
+)
+
+// 配置中可以选择的分发策略
+const (
+	DispatchModeConn        = "conn"         // 按照ConnID分发，同一个连接的请求按顺序处理
+	DispatchModeKey         = "key"          // 按照Router提供的key分发，相同key的请求按顺序处理
+	DispatchModeLeastLoaded = "least_loaded" // 分发给等待处理的请求最少的消息队列，不保证同一个连接的请求按顺序处理
+	DispatchModeLogic       = "logic"        // 全部交给单独的逻辑队列，由一个Goroutine按顺序处理
+)
+
+// NewDispatcher 根据配置的策略名称得到对应的分发策略，未知的策略按照conn处理
+func NewDispatcher(mode string) ziface.IDispatcher {
+	switch mode {
+	case DispatchModeKey:
+		return &KeyDispatcher{}
+	case DispatchModeLeastLoaded:
+		return &LeastLoadedDispatcher{}
+	case DispatchModeLogic:
+		return NewLogicDispatcher(nil)
+	default:
+		return &ConnDispatcher{}
+	}
+}
+
+// ConnDispatcher 按照ConnID分发请求
+type ConnDispatcher struct {
+}
+
+func (d *ConnDispatcher) Dispatch(request ziface.IRequest, router ziface.IRouter, pool ziface.IWorkerPool) int {
+	return int(request.GetConnection().GetConnID() % uint32(pool.GetTaskQueueNum()))
+}
+
+// KeyDispatcher 按照Router提供的key分发请求，Router没有实现IKeyRouter或者没有提供key时按照ConnID分发
+type KeyDispatcher struct {
+	ConnDispatcher
+}
+
+func (d *KeyDispatcher) Dispatch(request ziface.IRequest, router ziface.IRouter, pool ziface.IWorkerPool) int {
+	if keyRouter, ok := router.(ziface.IKeyRouter); ok {
+		if key, ok := keyRouter.DispatchKey(request); ok {
+			return int(key % uint64(pool.GetTaskQueueNum()))
+		}
+	}
+	return d.ConnDispatcher.Dispatch(request, router, pool)
+}
+
+// LeastLoadedDispatcher 将请求分发给等待处理的请求最少的消息队列
+// 同一个连接的请求可能由不同的Worker并发处理，适用于请求之间没有顺序要求的业务
+type LeastLoadedDispatcher struct {
+	next uint32 // 下一次开始比较的消息队列编号，避免请求都集中在编号较小的消息队列，原子操作
+}
+
+func (d *LeastLoadedDispatcher) Dispatch(request ziface.IRequest, router ziface.IRouter, pool ziface.IWorkerPool) int {
+	num := pool.GetTaskQueueNum()
+	start := int(atomic.AddUint32(&d.next, 1) % uint32(num))
+	best, bestLen := start, pool.GetTaskQueueLen(start)
+	for i := 1; i < num && bestLen > 0; i++ {
+		queueId := (start + i) % num
+		if l := pool.GetTaskQueueLen(queueId); l < bestLen {
+			best, bestLen = queueId, l
+		}
+	}
+	return best
+}
+
+// LogicDispatcher 将指定MsgID的请求交给单独的逻辑队列，由一个Goroutine按顺序处理，适用于修改共享状态的业务
+// 其余的请求交给next分发
+type LogicDispatcher struct {
+	msgIds map[uint32]bool    // 交给逻辑队列的MsgID，为空则全部的请求都交给逻辑队列
+	next   ziface.IDispatcher // 其余请求的分发策略
+}
+
+// NewLogicDispatcher 初始化方法，没有指定msgIds时全部的请求都交给逻辑队列，next为nil时按照ConnID分发
+func NewLogicDispatcher(next ziface.IDispatcher, msgIds ...uint32) *LogicDispatcher {
+	if next == nil {
+		next = &ConnDispatcher{}
+	}
+	d := &LogicDispatcher{
+		msgIds: make(map[uint32]bool),
+		next:   next,
+	}
+	for _, msgId := range msgIds {
+		d.msgIds[msgId] = true
+	}
+	return d
+}
+
+func (d *LogicDispatcher) Dispatch(request ziface.IRequest, router ziface.IRouter, pool ziface.IWorkerPool) int {
+	if len(d.msgIds) == 0 || d.msgIds[request.GetMsgID()] {
+		return ziface.LogicQueue
+	}
+	return d.next.Dispatch(request, router, pool)
+}
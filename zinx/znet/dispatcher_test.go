@@ -0,0 +1,127 @@
+package znet
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// fakeWorkerPool 用于测试分发策略的Worker工作池状态
+type fakeWorkerPool struct {
+	lens []int
+}
+
+func (p *fakeWorkerPool) GetTaskQueueNum() int            { return len(p.lens) }
+func (p *fakeWorkerPool) GetTaskQueueLen(queueId int) int { return p.lens[queueId] }
+func (p *fakeWorkerPool) GetWorkerNum() int               { return len(p.lens) }
+
+// keyRouter 以消息内容的第一个字节作为分发key，记录每个key的请求的处理顺序
+type keyRouter struct {
+	BaseRouter
+	lock  sync.Mutex
+	order map[uint64][]byte
+}
+
+func (r *keyRouter) DispatchKey(request ziface.IRequest) (uint64, bool) {
+	if len(request.GetData()) == 0 {
+		return 0, false
+	}
+	return uint64(request.GetData()[0]), true
+}
+
+func (r *keyRouter) Handle(request ziface.IRequest) {
+	time.Sleep(2 * time.Millisecond)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := uint64(request.GetData()[0])
+	r.order[key] = append(r.order[key], request.GetData()[1])
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	pool := &fakeWorkerPool{lens: []int{3, 0, 2, 1}}
+	conn := &Connection{ConnID: 5}
+	router := &keyRouter{}
+
+	tests := []struct {
+		name       string
+		dispatcher ziface.IDispatcher
+		request    *Request
+		router     ziface.IRouter
+		want       int
+	}{
+		{"conn", NewDispatcher(DispatchModeConn), &Request{conn: conn, msg: NewMessage(1, nil)}, nil, 1},
+		{"key", NewDispatcher(DispatchModeKey), &Request{conn: conn, msg: NewMessage(1, []byte{6})}, router, 2},
+		{"key fallback", NewDispatcher(DispatchModeKey), &Request{conn: conn, msg: NewMessage(1, nil)}, router, 1},
+		{"least loaded", NewDispatcher(DispatchModeLeastLoaded), &Request{conn: conn, msg: NewMessage(1, nil)}, nil, 1},
+		{"logic", NewDispatcher(DispatchModeLogic), &Request{conn: conn, msg: NewMessage(1, nil)}, nil, ziface.LogicQueue},
+		{"logic msg", NewLogicDispatcher(nil, 2), &Request{conn: conn, msg: NewMessage(2, nil)}, nil, ziface.LogicQueue},
+		{"logic other msg", NewLogicDispatcher(nil, 2), &Request{conn: conn, msg: NewMessage(1, nil)}, nil, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.dispatcher.Dispatch(tt.request, tt.router, pool); got != tt.want {
+			t.Errorf("%s: Dispatch = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 按key分发时相同key的请求按顺序处理，积压时Worker数量增加，空闲之后减少到最小值
+func TestMsgHandler_Autoscale(t *testing.T) {
+	m := NewMsgHandlerWithPool(1, 100, PanicPolicyContinue)
+	m.SetDispatcher(NewDispatcher(DispatchModeKey))
+	m.SetAutoscale(1, 4, 10*time.Millisecond)
+	router := &keyRouter{order: make(map[uint64][]byte)}
+	m.AddRouter(1, router)
+	m.StartWorkerPool()
+	defer m.StopWorkerPool()
+
+	conn := &Connection{ConnID: 1}
+	var wg sync.WaitGroup
+	wg.Add(4 * 20)
+	for i := 0; i < 20; i++ {
+		for key := byte(0); key < 4; key++ {
+			if err := m.SendMsgToTaskQueue(&Request{conn: conn, msg: NewMessage(1, []byte{key, byte(i)}), onDone: wg.Done}, -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	maxWorkers := 0
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case <-time.After(5 * time.Millisecond):
+			if n := m.GetWorkerNum(); n > maxWorkers {
+				maxWorkers = n
+			}
+		}
+	}
+	if maxWorkers < 2 {
+		t.Error("worker num did not scale up, max =", maxWorkers)
+	}
+
+	for key, order := range router.order {
+		if len(order) != 20 {
+			t.Errorf("key %d handled %d requests, want 20", key, len(order))
+		}
+		for i, v := range order {
+			if int(v) != i {
+				t.Fatalf("requests of key %d handled out of order: %v", key, order)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for m.GetWorkerNum() > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := m.GetWorkerNum(); n != 1 {
+		t.Error("worker num did not scale down, num =", n)
+	}
+}
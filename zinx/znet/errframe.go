@@ -0,0 +1,157 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// ErrorMsgID 框架保留的错误消息ID，服务器拒绝请求时发送给客户端，该消息不会交给MsgHandler处理
+// 消息内容为错误码ErrCode uint32（小端字节序）+ 错误描述，回复的是RPC请求时使用与请求相同的序列号
+const ErrorMsgID uint32 = 0xFFFE
+
+// ErrCode 错误消息中的错误码
+type ErrCode uint32
+
+const (
+	ErrCodeServerBusy      ErrCode = 1 // 服务器繁忙，请求没有被处理（消息队列已满，或者新的连接因为消息队列饱和被拒绝）
+	ErrCodeOverCapacity    ErrCode = 2 // 连接数已经达到MaxConn，新的连接被拒绝
+	ErrCodePackageTooLarge ErrCode = 3 // 消息内容的长度超出了允许的最大包长度，连接被断开
+	ErrCodeUnknownMsgID    ErrCode = 4 // 没有注册对应的Router，请求没有被处理，连接不会断开
+	ErrCodeRateLimited     ErrCode = 5 // 超出限流，连接被断开
+	ErrCodeKicked          ErrCode = 6 // 被管理员踢出，连接被断开
+)
+
+// 客户端收到错误消息时返回的错误，可以通过errors.Is判断错误码
+var (
+	ErrServerBusy      = &ServerError{Code: ErrCodeServerBusy}
+	ErrOverCapacity    = &ServerError{Code: ErrCodeOverCapacity}
+	ErrPackageTooLarge = &ServerError{Code: ErrCodePackageTooLarge}
+	ErrUnknownMsgID    = &ServerError{Code: ErrCodeUnknownMsgID}
+	ErrRateLimited     = &ServerError{Code: ErrCodeRateLimited}
+	ErrKicked          = &ServerError{Code: ErrCodeKicked}
+)
+
+// errorFrameTimeout 拒绝新的连接时发送错误消息的最长时间
+const errorFrameTimeout = time.Second
+
+func (c ErrCode) String() string {
+	switch c {
+	case ErrCodeServerBusy:
+		return "server busy"
+	case ErrCodeOverCapacity:
+		return "server over capacity"
+	case ErrCodePackageTooLarge:
+		return "package too large"
+	case ErrCodeUnknownMsgID:
+		return "unknown msg id"
+	case ErrCodeRateLimited:
+		return "rate limited"
+	case ErrCodeKicked:
+		return "kicked"
+	default:
+		return "error code " + strconv.Itoa(int(c))
+	}
+}
+
+// packErrorData 将错误码和错误描述封装为错误消息的内容
+func packErrorData(code ErrCode, message string) []byte {
+	data := make([]byte, 4+len(message))
+	binary.LittleEndian.PutUint32(data, uint32(code))
+	copy(data[4:], message)
+	return data
+}
+
+// unpackErrorData 从错误消息的内容中得到错误码和错误描述
+func unpackErrorData(data []byte) (ErrCode, string, error) {
+	if len(data) < 4 {
+		return 0, "", errors.New("invalid error frame")
+	}
+	return ErrCode(binary.LittleEndian.Uint32(data)), string(data[4:]), nil
+}
+
+// sendError 给客户端发送错误消息，seq为被拒绝的RPC请求的序列号
+// 错误消息放入带缓冲的发送队列，不等待Writer，发送队列已满时丢弃错误消息，避免接收过慢的客户端阻塞Reader或者Worker
+func (c *Connection) sendError(seq uint32, code ErrCode, message string) error {
+	c.closeLock.RLock()
+	isClosed := c.isClosed
+	c.closeLock.RUnlock()
+	if isClosed {
+		return errors.New("connection closed when sending error frame")
+	}
+
+	msg := NewMessage(ErrorMsgID, packErrorData(code, message))
+	msg.SetSeq(seq)
+	binaryMsg, err := c.packMsg(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.msgBuffChan <- binaryMsg:
+		c.metrics.MsgSent(ErrorMsgID, len(binaryMsg))
+		return nil
+	default:
+		return errors.New("send buff full, error frame dropped")
+	}
+}
+
+// closeWithError 将错误消息放入发送队列之后停止连接，Writer退出之前会将发送队列中的错误消息发送给客户端
+func (c *Connection) closeWithError(code ErrCode, message string) {
+	if err := c.sendError(0, code, message); err != nil {
+		zlog.Debug("Send error frame error", zlog.ConnID(c.ConnID), zlog.Err(err))
+	}
+	c.Stop()
+}
+
+// Kick 给客户端发送被踢出的错误消息，再停止连接
+func (c *Connection) Kick(reason string) {
+	zlog.Info("Connection kicked", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.Conn.RemoteAddr()), zlog.Any("reason", reason))
+	c.closeWithError(ErrCodeKicked, reason)
+}
+
+// rejectConn 还没有创建Connection的新连接被拒绝时，直接给客户端写入错误消息，再关闭连接
+func rejectConn(dp ziface.IDataPack, conn net.Conn, code ErrCode) {
+	defer conn.Close()
+
+	binaryMsg, err := dp.Pack(NewMessage(ErrorMsgID, packErrorData(code, code.String())))
+	if err != nil {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(errorFrameTimeout))
+	if _, err := conn.Write(binaryMsg); err != nil {
+		zlog.Debug("Send error frame error", zlog.RemoteAddr(conn.RemoteAddr()), zlog.Err(err))
+	}
+}
+
+// ServerError 服务器通过错误消息返回的错误
+type ServerError struct {
+	Code    ErrCode // 错误码
+	Message string  // 错误描述
+}
+
+// newServerError 根据错误消息的内容创建ServerError
+func newServerError(data []byte) *ServerError {
+	code, message, err := unpackErrorData(data)
+	if err != nil {
+		return &ServerError{Message: err.Error()}
+	}
+	return &ServerError{Code: code, Message: message}
+}
+
+func (e *ServerError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.Code.String()
+	}
+	return "zinx server error: " + message + " (" + strconv.Itoa(int(e.Code)) + ")"
+}
+
+// Is 错误码相同的ServerError被认为是同一种错误，例如errors.Is(err, ErrOverCapacity)
+func (e *ServerError) Is(target error) bool {
+	t, ok := target.(*ServerError)
+	return ok && t.Code == e.Code
+}
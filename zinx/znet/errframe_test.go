@@ -0,0 +1,134 @@
+package znet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// newErrorClient 创建一个将服务器错误消息转交给测试的客户端
+func newErrorClient(port int, dp ziface.IDataPack) (*Client, chan error, chan struct{}) {
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.ReconnectMinBackoff = 20 * time.Millisecond
+	c.SetDataPack(dp)
+	serverErrs := make(chan error, 1)
+	connected := make(chan struct{}, 1)
+	c.SetOnServerError(func(conn ziface.IConnection, err error) {
+		select {
+		case serverErrs <- err:
+		default:
+		}
+	})
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		select {
+		case connected <- struct{}{}:
+		default:
+		}
+	})
+	return c, serverErrs, connected
+}
+
+// waitServerError 等待客户端收到服务器的错误消息，并检查错误码
+func waitServerError(t *testing.T, serverErrs chan error, target error) {
+	t.Helper()
+	select {
+	case err := <-serverErrs:
+		if !errors.Is(err, target) {
+			t.Errorf("server error = %v, want %v", err, target)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client did not receive %v", target)
+	}
+}
+
+// expectRejected 检查连接先收到指定错误码的错误消息，随后被服务器关闭
+func expectRejected(t *testing.T, conn net.Conn, dp ziface.IDataPack, target error) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := dp.ReadMsg(conn)
+	if err != nil {
+		t.Fatal("read error frame:", err)
+	}
+	if msg.GetMsgID() != ErrorMsgID || !errors.Is(newServerError(msg.GetData()), target) {
+		t.Errorf("got msg %d %q, want error frame %v", msg.GetMsgID(), msg.GetData(), target)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("connection should be closed after error frame, read error:", err)
+	}
+}
+
+// 连接数已满时新的连接收到错误消息，被踢出的连接收到踢出的原因
+func TestServer_RejectAndKick(t *testing.T) {
+	connIds := make(chan uint32, 1)
+	port := freePort(t)
+	s := NewServer(
+		WithAddress("127.0.0.1", port),
+		WithMaxConn(1),
+		WithOnConnStart(func(conn ziface.IConnection) {
+			select {
+			case connIds <- conn.GetConnID():
+			default:
+			}
+		}),
+	).(*Server)
+	s.Start()
+	defer s.Stop()
+
+	c1, errs1, connected1 := newErrorClient(port, NewDataPack())
+	c1.Start()
+	defer c1.Stop()
+	<-connected1
+	connId := <-connIds
+
+	c2, errs2, _ := newErrorClient(port, NewDataPack())
+	c2.Start()
+	defer c2.Stop()
+	waitServerError(t, errs2, ErrOverCapacity)
+
+	if err := s.GetConnManager().Kick(connId, "maintenance"); err != nil {
+		t.Fatal("Kick error:", err)
+	}
+	select {
+	case err := <-errs1:
+		if !errors.Is(err, ErrKicked) || err.(*ServerError).Message != "maintenance" {
+			t.Errorf("server error = %v, want kicked with reason", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("kicked client did not receive error frame")
+	}
+	if err := s.GetConnManager().Kick(12345, "unknown"); err == nil {
+		t.Error("kick unknown connection succeeded")
+	}
+}
+
+// 请求没有注册的MsgID时Call立即返回错误，发送过大的消息时收到错误消息之后连接被断开
+func TestServer_ErrorFrames(t *testing.T) {
+	port := freePort(t)
+	s := NewServer(WithAddress("127.0.0.1", port), WithCodec(NewSeqDataPack())).(*Server)
+	s.Start()
+	defer s.Stop()
+
+	c, _, connected := newErrorClient(port, NewSeqDataPack())
+	c.Start()
+	defer c.Stop()
+	<-connected
+
+	start := time.Now()
+	if _, err := c.Call(99, []byte("ping"), 2*time.Second); !errors.Is(err, ErrUnknownMsgID) {
+		t.Errorf("Call unknown msg id error = %v, want %v", err, ErrUnknownMsgID)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Call waited for timeout instead of returning the error frame")
+	}
+
+	// head中的DataLen超出了最大包长度
+	conn := dialRetry(t, s.Addr().String())
+	defer conn.Close()
+	dp := NewSeqDataPack()
+	head, _ := dp.Pack(&Message{ID: 1, DataLen: 1 << 20})
+	conn.Write(head)
+	expectRejected(t, conn, dp, ErrPackageTooLarge)
+}
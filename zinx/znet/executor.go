@@ -0,0 +1,35 @@
+package znet
+
+import (
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// newExecChan 没有开启Worker工作池并且配置了ordered_per_conn时，为连接创建串行执行队列，否则返回nil
+func newExecChan(server ziface.IServer) chan ziface.IRequest {
+	conf := server.GetConfig()
+	if conf.WorkerPoolSize > 0 || !conf.OrderedPerConn {
+		return nil
+	}
+	return make(chan ziface.IRequest, conf.OrderedQueueLen)
+}
+
+// startExecutor 当前连接的串行执行队列，同一个连接的请求在这一个Goroutine中按顺序处理，不同的连接之间并发处理
+// Reader退出时关闭执行队列，处理完剩余的请求之后退出
+func (c *Connection) startExecutor() {
+	zlog.Debug("Executor goroutine is running", zlog.ConnID(c.ConnID))
+	defer zlog.Debug("Executor exit", zlog.ConnID(c.ConnID))
+
+	for request := range c.execChan {
+		c.MsgHandler.DoMsgHandle(request)
+	}
+}
+
+// sendToExecutor 将请求交给当前连接的串行执行队列，队列已满时等待，连接开始停止时丢弃请求
+func (c *Connection) sendToExecutor(request *Request) {
+	select {
+	case c.execChan <- request:
+	case <-c.stopChan:
+		request.done()
+	}
+}
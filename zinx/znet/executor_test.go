@@ -0,0 +1,69 @@
+package znet
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// orderRouter 处理耗时随机，记录每个连接的请求的处理顺序
+type orderRouter struct {
+	BaseRouter
+	lock  sync.Mutex
+	order map[uint32][]byte
+	done  chan struct{}
+}
+
+func (r *orderRouter) Handle(request ziface.IRequest) {
+	time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	connId := request.GetConnection().GetConnID()
+	r.order[connId] = append(r.order[connId], request.GetData()[0])
+	r.done <- struct{}{}
+}
+
+// 没有开启Worker工作池时，同一个连接的请求按照发送的顺序处理
+func TestConnection_OrderedPerConn(t *testing.T) {
+	s := NewServer(WithAddress("127.0.0.1", freePort(t)), WithWorkerPool(0, 16)).(*Server)
+	router := &orderRouter{order: make(map[uint32][]byte), done: make(chan struct{}, 100)}
+	s.AddRouter(1, router)
+	s.Start()
+	defer s.Stop()
+
+	dp := NewDataPack()
+	for i := 0; i < 2; i++ {
+		conn := dialRetry(t, s.Addr().String())
+		defer conn.Close()
+
+		for n := 0; n < 20; n++ {
+			binaryMsg, _ := dp.Pack(NewMessage(1, []byte{byte(n)}))
+			if _, err := conn.Write(binaryMsg); err != nil {
+				t.Fatal("Client write error:", err)
+			}
+		}
+	}
+
+	for i := 0; i < 40; i++ {
+		select {
+		case <-router.done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("requests not handled, handled =", i)
+		}
+	}
+
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	if len(router.order) != 2 {
+		t.Fatal("handled requests of", len(router.order), "connections, want 2")
+	}
+	for connId, order := range router.order {
+		for i, v := range order {
+			if int(v) != i {
+				t.Fatalf("requests of conn %d handled out of order: %v", connId, order)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package znet
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// GroupManager 连接分组管理模块
+type GroupManager struct {
+	groups     map[string]map[uint32]ziface.IConnection // 分组名称 -> 分组中的连接集合，key为ConnID
+	connGroups map[uint32]map[string]struct{}           // ConnID -> 连接加入的分组集合
+	lock       sync.RWMutex                             // 保护分组集合的读写锁
+}
+
+// errJoinStoppingConn 连接已经开始停止，之后的LeaveAll不会再移出它，因此不允许加入分组
+var errJoinStoppingConn = errors.New("connection is stopping when joining group")
+
+func NewGroupManager() *GroupManager {
+	return &GroupManager{
+		groups:     make(map[string]map[uint32]ziface.IConnection),
+		connGroups: make(map[uint32]map[string]struct{}),
+	}
+}
+
+// Join 将连接加入分组，与LeaveAll在同一把锁中检查连接是否已经开始停止，
+// 停止的连接要么在这里被拒绝，要么在之后的LeaveAll中被移出
+func (gm *GroupManager) Join(group string, conn ziface.IConnection) error {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	if c, ok := conn.(*Connection); ok && c.stopped() {
+		return errJoinStoppingConn
+	}
+
+	members, ok := gm.groups[group]
+	if !ok {
+		members = make(map[uint32]ziface.IConnection)
+		gm.groups[group] = members
+	}
+	members[conn.GetConnID()] = conn
+
+	groups, ok := gm.connGroups[conn.GetConnID()]
+	if !ok {
+		groups = make(map[string]struct{})
+		gm.connGroups[conn.GetConnID()] = groups
+	}
+	groups[group] = struct{}{}
+	zlog.Debug("Join group", zlog.ConnID(conn.GetConnID()), zlog.Any("group", group), zlog.Any("memberNum", len(members)))
+	return nil
+}
+
+func (gm *GroupManager) Leave(group string, conn ziface.IConnection) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	gm.leave(group, conn.GetConnID())
+	if groups, ok := gm.connGroups[conn.GetConnID()]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(gm.connGroups, conn.GetConnID())
+		}
+	}
+}
+
+// LeaveAll 将连接移出全部分组，连接停止时会自动调用
+func (gm *GroupManager) LeaveAll(conn ziface.IConnection) {
+	gm.lock.Lock()
+	defer gm.lock.Unlock()
+
+	for group := range gm.connGroups[conn.GetConnID()] {
+		gm.leave(group, conn.GetConnID())
+	}
+	delete(gm.connGroups, conn.GetConnID())
+}
+
+// leave 将连接从分组的连接集合中删除，分组中没有连接时删除分组，调用方需要持有写锁
+func (gm *GroupManager) leave(group string, connId uint32) {
+	members, ok := gm.groups[group]
+	if !ok {
+		return
+	}
+	delete(members, connId)
+	if len(members) == 0 {
+		delete(gm.groups, group)
+	}
+	zlog.Debug("Leave group", zlog.ConnID(connId), zlog.Any("group", group), zlog.Any("memberNum", len(members)))
+}
+
+func (gm *GroupManager) GetMembers(group string) []ziface.IConnection {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	members := make([]ziface.IConnection, 0, len(gm.groups[group]))
+	for _, conn := range gm.groups[group] {
+		members = append(members, conn)
+	}
+	return members
+}
+
+// GetGroups 获取连接加入的全部分组，按照名称排序
+func (gm *GroupManager) GetGroups(conn ziface.IConnection) []string {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	groups := make([]string, 0, len(gm.connGroups[conn.GetConnID()]))
+	for group := range gm.connGroups[conn.GetConnID()] {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// GetGroupNames 获取当前全部的分组名称，按照名称排序
+func (gm *GroupManager) GetGroupNames() []string {
+	gm.lock.RLock()
+	defer gm.lock.RUnlock()
+
+	names := make([]string, 0, len(gm.groups))
+	for group := range gm.groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Broadcast 发送消息给分组中的全部连接，消息只封包一次，跳过已经关闭的连接
+// 返回发送失败的连接及其错误，全部成功时为nil
+func (gm *GroupManager) Broadcast(group string, msgId uint32, data []byte) map[uint32]error {
+	// 不能在持有锁的同时发送，发送队列已满时连接可能断开并调用LeaveAll
+	return multicast(gm.GetMembers(group), nil, msgId, data)
+}
@@ -0,0 +1,113 @@
+package znet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// 连接可以加入多个分组，分组可以枚举成员，成员全部离开之后分组被删除
+func TestGroupManager_JoinLeave(t *testing.T) {
+	s := NewServer().(*Server)
+	gm := s.GetGroupManager()
+	conn1, conn2 := newTestConn(s, 1), newTestConn(s, 2)
+
+	for _, join := range []struct {
+		group string
+		conn  *Connection
+	}{{"guild", conn1}, {"guild", conn2}, {"instance", conn1}} {
+		if err := gm.Join(join.group, join.conn); err != nil {
+			t.Fatal("Join error:", err)
+		}
+	}
+	if groups := gm.GetGroups(conn1); !reflect.DeepEqual(groups, []string{"guild", "instance"}) {
+		t.Errorf("groups of conn 1 = %v", groups)
+	}
+	if n := len(gm.GetMembers("guild")); n != 2 {
+		t.Errorf("guild has %d members, want 2", n)
+	}
+
+	if failures := gm.Broadcast("guild", 2, []byte("hello guild")); failures != nil {
+		t.Errorf("broadcast failures = %v", failures)
+	}
+	if len(conn1.msgBuffChan) != 1 || len(conn2.msgBuffChan) != 1 {
+		t.Error("guild members did not receive the broadcast")
+	}
+
+	gm.Leave("instance", conn1)
+	if names := gm.GetGroupNames(); !reflect.DeepEqual(names, []string{"guild"}) {
+		t.Errorf("group names = %v, want only guild", names)
+	}
+	gm.LeaveAll(conn2)
+	if members := gm.GetMembers("guild"); len(members) != 1 || members[0] != conn1 {
+		t.Errorf("guild members = %v, want only conn 1", members)
+	}
+	if groups := gm.GetGroups(conn2); len(groups) != 0 {
+		t.Errorf("groups of conn 2 = %v, want none", groups)
+	}
+}
+
+// 已经开始停止的连接不能再加入分组，避免LeaveAll之后留在分组中
+func TestGroupManager_JoinStopping(t *testing.T) {
+	s := NewServer().(*Server)
+	gm := s.GetGroupManager()
+	conn := newTestConn(s, 1)
+	close(conn.stopChan)
+
+	if err := gm.Join("guild", conn); err == nil {
+		t.Error("stopping connection joined group")
+	}
+	if names := gm.GetGroupNames(); len(names) != 0 {
+		t.Errorf("groups = %v, want none", names)
+	}
+}
+
+// 连接停止之后自动离开全部分组，OnConnStop中仍然可以查询所在的分组
+func TestGroupManager_RemoveOnStop(t *testing.T) {
+	groupsOnStop := make(chan []string, 1)
+	var s *Server
+	port := freePort(t)
+	s = NewServer(
+		WithAddress("127.0.0.1", port),
+		WithOnConnStart(func(conn ziface.IConnection) {
+			s.GetGroupManager().Join("world", conn)
+			s.GetGroupManager().Join("spectators", conn)
+		}),
+		WithOnConnStop(func(conn ziface.IConnection) {
+			groupsOnStop <- s.GetGroupManager().GetGroups(conn)
+		}),
+	).(*Server)
+	s.Start()
+	defer s.Stop()
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.ReconnectMinBackoff = 20 * time.Millisecond
+	connected := make(chan struct{}, 1)
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		connected <- struct{}{}
+	})
+	c.Start()
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not connect")
+	}
+	c.Stop()
+
+	select {
+	case groups := <-groupsOnStop:
+		if !reflect.DeepEqual(groups, []string{"spectators", "world"}) {
+			t.Errorf("groups in OnConnStop = %v", groups)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnConnStop was not called")
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(s.GetGroupManager().GetGroupNames()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("groups were not removed after connection stopped:", s.GetGroupManager().GetGroupNames())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
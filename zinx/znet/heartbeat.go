@@ -0,0 +1,55 @@
+package znet
+
+import (
+	"sync/atomic"
+	"time"
+	"zinx/zlog"
+)
+
+// HeartbeatMsgID 框架保留的心跳消息ID
+// 客户端发送该消息（ping），服务器收到后原样回复同一个ID的消息（pong），该消息不会交给MsgHandler处理
+const HeartbeatMsgID uint32 = 0xFFFF
+
+// updateActivity 记录当前连接最后一次收到客户端数据的时间
+func (c *Connection) updateActivity() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+func (c *Connection) GetLastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+}
+
+// handleHeartbeat 回复客户端的心跳消息
+func (c *Connection) handleHeartbeat() {
+	if err := c.SendMsg(HeartbeatMsgID, nil); err != nil {
+		zlog.Debug("Reply heartbeat error", zlog.ConnID(c.ConnID), zlog.Err(err))
+	}
+}
+
+// StartHeartbeatChecker 定期检测当前连接是否空闲超时，超时则认为连接已经失效（例如客户端网络断开导致的半开连接）
+func (c *Connection) StartHeartbeatChecker() {
+	conf := c.Server.GetConfig()
+	idleTimeout := time.Duration(conf.IdleTimeout) * time.Millisecond
+	interval := time.Duration(conf.HeartbeatInterval) * time.Millisecond
+	if idleTimeout <= 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(c.GetLastActivity()) > idleTimeout {
+				zlog.Info("Idle timeout, connection is dead", zlog.ConnID(c.ConnID), zlog.RemoteAddr(c.RemoteAddr()))
+				// 交给开发者注册的Hook函数处理，默认停止当前连接
+				c.Server.CallOnConnDead(c)
+				return
+			}
+		// 连接已经退出，停止检测
+		case <-c.ExitChan:
+			return
+		}
+	}
+}
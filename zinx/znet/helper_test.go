@@ -0,0 +1,44 @@
+package znet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// dialRetry 连接服务器的地址，服务器还没有开始监听时每隔20毫秒重试一次，最多等待1秒
+func dialRetry(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			return conn
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("Client dial error:", err)
+	return nil
+}
+
+// freePort 获取一个当前空闲的本地端口，测试中的Server在这个端口上监听
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// newTestConn 创建一个不绑定socket的连接，发送的消息留在容量为1的发送队列中，用于检查广播、分组等发送结果
+func newTestConn(s *Server, connId uint32) *Connection {
+	return &Connection{
+		Server:      s,
+		ConnID:      connId,
+		msgBuffChan: make(chan []byte, 1),
+		ExitChan:    make(chan bool),
+		stopChan:    make(chan struct{}),
+	}
+}
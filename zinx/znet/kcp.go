@@ -0,0 +1,76 @@
+package znet
+
+import (
+	"errors"
+	"github.com/xtaci/kcp-go/v5"
+	"net"
+	"sync/atomic"
+)
+
+// Server支持的传输协议
+const (
+	TransportTCP = "tcp" // TCP
+	TransportKCP = "kcp" // KCP（基于UDP的可靠传输），没有TCP的队头阻塞，适合丢包较多的移动网络
+)
+
+// kcpListener 对KCP的监听器进行封装，为每个新建立的会话设置适合实时游戏的参数
+type kcpListener struct {
+	*kcp.Listener
+	closed int32 // 是否已经停止接收新的会话，原子操作
+}
+
+// listenKCP 监听KCP地址
+func listenKCP(addr string) (net.Listener, error) {
+	listener, err := kcp.ListenWithOptions(addr, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &kcpListener{Listener: listener}, nil
+}
+
+func (l *kcpListener) Accept() (net.Conn, error) {
+	session, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	// 已经停止接收新的会话
+	if atomic.LoadInt32(&l.closed) == 1 {
+		session.Close()
+		return nil, errors.New("kcp listener closed")
+	}
+	setKCPSession(session)
+	return session, nil
+}
+
+// Close 停止接收新的会话
+// KCP的会话与监听器共用同一个UDP socket，关闭socket会导致已经建立的会话无法继续收发数据，
+// 因此socket需要在所有连接停止之后再通过closeSocket关闭
+func (l *kcpListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	return nil
+}
+
+// closeSocket 关闭监听器的UDP socket
+func (l *kcpListener) closeSocket() error {
+	return l.Listener.Close()
+}
+
+// DialKCP 以KCP协议连接服务器，供客户端及测试使用
+func DialKCP(addr string) (net.Conn, error) {
+	session, err := kcp.DialWithOptions(addr, nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	setKCPSession(session)
+	return session, nil
+}
+
+// setKCPSession 设置KCP会话的参数
+func setKCPSession(session *kcp.UDPSession) {
+	// 开启快速模式：nodelay、10ms的内部更新间隔、2次ACK跨越即快速重传、关闭拥塞控制
+	session.SetNoDelay(1, 10, 2, 1)
+	// 流模式，与TCP一样由IDataPack处理消息的边界
+	session.SetStreamMode(true)
+	session.SetWindowSize(128, 128)
+	session.SetACKNoDelay(true)
+}
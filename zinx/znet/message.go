@@ -4,6 +4,7 @@ type Message struct {
 	ID      uint32 // 消息的ID
 	DataLen uint32 // 消息的长度
 	Data    []byte // 消息的内容
+	Seq     uint32 // 消息的序列号，只有SeqDataPack会将其封包
 }
 
 // NewMessage 创建一个消息
@@ -38,3 +39,11 @@ func (m *Message) SetDataLen(dataLen uint32) {
 func (m *Message) SetData(data []byte) {
 	m.Data = data
 }
+
+func (m *Message) GetSeq() uint32 {
+	return m.Seq
+}
+
+func (m *Message) SetSeq(seq uint32) {
+	m.Seq = seq
+}
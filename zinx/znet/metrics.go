@@ -0,0 +1,324 @@
+package znet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/zlog"
+)
+
+// 连接被拒绝的原因
+const (
+	RejectReasonMaxConn      = "max_conn"      // 超过最大连接数
+	RejectReasonTLSHandshake = "tls_handshake" // TLS握手失败
+	RejectReasonOverload     = "overload"      // 消息队列已经饱和
+	RejectReasonStopping     = "stopping"      // Server已经开始停止
+)
+
+// unknownMsgLabel 没有注册Router的MsgID统计在一起时使用的msg_id标签
+const unknownMsgLabel = "unknown"
+
+// latencyBuckets 业务处理耗时直方图的区间上限（秒）
+var latencyBuckets = []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// msgMetrics 一个MsgID的统计数据，全部为原子操作
+type msgMetrics struct {
+	msgsIn     uint64   // 收到的消息数
+	bytesIn    uint64   // 收到的字节数（包括head）
+	msgsOut    uint64   // 发送的消息数
+	bytesOut   uint64   // 发送的字节数（包括head）
+	latency    []uint64 // 业务处理耗时落在每个区间内的次数（非累计）
+	latencyInf uint64   // 业务处理耗时超过最大区间的次数
+	latencySum uint64   // 业务处理的总耗时（纳秒）
+}
+
+// Metrics 统计服务器的运行数据，以Prometheus文本格式输出
+// 每个Server有自己的Metrics，为nil的Metrics不记录任何数据（例如客户端以及不属于Server的连接）
+type Metrics struct {
+	activeConns   int64                   // 当前的连接数，原子操作
+	acceptedConns uint64                  // 已经接受的连接数，原子操作
+	rejectedConns map[string]*uint64      // 每种原因被拒绝的连接数
+	overloads     map[string]*uint64      // 消息队列已满时按照每种策略处理的请求数
+	msgs          map[uint32]*msgMetrics  // 每个MsgID的统计数据
+	unknownMsgs   *msgMetrics             // 没有注册Router的MsgID的统计数据
+	isRouted      func(msgId uint32) bool // 判断MsgID是否注册了Router，为nil则除框架保留的MsgID之外全部统计在unknownMsgs中
+	lock          sync.RWMutex            // 保护统计数据集合的锁
+}
+
+// NewMetrics 初始化Metrics模块
+func NewMetrics() *Metrics {
+	return &Metrics{
+		rejectedConns: make(map[string]*uint64),
+		overloads:     make(map[string]*uint64),
+		msgs:          make(map[uint32]*msgMetrics),
+		unknownMsgs:   newMsgMetrics(),
+	}
+}
+
+// labeledMsgMetrics 输出时msg_id标签对应的统计数据
+type labeledMsgMetrics struct {
+	label string
+	mm    *msgMetrics
+}
+
+func newMsgMetrics() *msgMetrics {
+	return &msgMetrics{latency: make([]uint64, len(latencyBuckets))}
+}
+
+// getMsg 获取MsgID的统计数据，第一次获取时创建
+func (m *Metrics) getMsg(msgId uint32) *msgMetrics {
+	m.lock.RLock()
+	mm, ok := m.msgs[msgId]
+	m.lock.RUnlock()
+	if ok {
+		return mm
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if mm, ok = m.msgs[msgId]; !ok {
+		mm = newMsgMetrics()
+		m.msgs[msgId] = mm
+	}
+	return mm
+}
+
+// getReceivedMsg 获取收到的MsgID的统计数据，MsgID由客户端决定，
+// 没有注册Router的MsgID全部统计在unknownMsgs中，避免统计数据随着任意的MsgID无限增长
+func (m *Metrics) getReceivedMsg(msgId uint32) *msgMetrics {
+	switch msgId {
+	case HeartbeatMsgID, ErrorMsgID, CompressMsgID, KeyExchangeMsgID:
+		return m.getMsg(msgId)
+	}
+	if m.isRouted == nil || !m.isRouted(msgId) {
+		return m.unknownMsgs
+	}
+	return m.getMsg(msgId)
+}
+
+// ConnOpened 记录一个新的连接
+func (m *Metrics) ConnOpened() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.activeConns, 1)
+}
+
+// ConnClosed 记录一个连接已经关闭
+func (m *Metrics) ConnClosed() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.activeConns, -1)
+}
+
+// ConnAccepted 记录一个被接受的连接
+func (m *Metrics) ConnAccepted() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.acceptedConns, 1)
+}
+
+// ConnRejected 记录一个被拒绝的连接
+func (m *Metrics) ConnRejected(reason string) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(m.getCounter(m.rejectedConns, reason), 1)
+}
+
+// TaskOverloaded 记录一个因为消息队列已满而按照policy处理的请求
+func (m *Metrics) TaskOverloaded(policy string) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(m.getCounter(m.overloads, policy), 1)
+}
+
+// getCounter 获取counters中label对应的计数器，第一次获取时创建
+func (m *Metrics) getCounter(counters map[string]*uint64, label string) *uint64 {
+	m.lock.RLock()
+	counter, ok := counters[label]
+	m.lock.RUnlock()
+	if ok {
+		return counter
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if counter, ok = counters[label]; !ok {
+		counter = new(uint64)
+		counters[label] = counter
+	}
+	return counter
+}
+
+// MsgReceived 记录收到的一个消息
+func (m *Metrics) MsgReceived(msgId uint32, bytes int) {
+	if m == nil {
+		return
+	}
+	mm := m.getReceivedMsg(msgId)
+	atomic.AddUint64(&mm.msgsIn, 1)
+	atomic.AddUint64(&mm.bytesIn, uint64(bytes))
+}
+
+// MsgSent 记录发送的一个消息，发送的MsgID由服务器决定，每个MsgID分别统计
+func (m *Metrics) MsgSent(msgId uint32, bytes int) {
+	if m == nil {
+		return
+	}
+	mm := m.getMsg(msgId)
+	atomic.AddUint64(&mm.msgsOut, 1)
+	atomic.AddUint64(&mm.bytesOut, uint64(bytes))
+}
+
+// ObserveLatency 记录一次业务处理的耗时
+func (m *Metrics) ObserveLatency(msgId uint32, cost time.Duration) {
+	if m == nil {
+		return
+	}
+	mm := m.getReceivedMsg(msgId)
+	atomic.AddUint64(&mm.latencySum, uint64(cost))
+
+	seconds := cost.Seconds()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			atomic.AddUint64(&mm.latency[i], 1)
+			return
+		}
+	}
+	atomic.AddUint64(&mm.latencyInf, 1)
+}
+
+// GetActiveConns 获取当前的连接数
+func (m *Metrics) GetActiveConns() int64 {
+	return atomic.LoadInt64(&m.activeConns)
+}
+
+// WritePrometheus 以Prometheus文本格式输出统计数据，taskQueueLens为每个消息队列的长度
+func (m *Metrics) WritePrometheus(w io.Writer, taskQueueLens []int) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	writeHeader := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	writeHeader("zinx_connections_active", "gauge", "Number of active connections.")
+	fmt.Fprintf(bw, "zinx_connections_active %d\n", m.GetActiveConns())
+
+	writeHeader("zinx_connections_accepted_total", "counter", "Total number of accepted connections.")
+	fmt.Fprintf(bw, "zinx_connections_accepted_total %d\n", atomic.LoadUint64(&m.acceptedConns))
+
+	m.lock.RLock()
+	reasons := make([]string, 0, len(m.rejectedConns))
+	for reason := range m.rejectedConns {
+		reasons = append(reasons, reason)
+	}
+	policies := make([]string, 0, len(m.overloads))
+	for policy := range m.overloads {
+		policies = append(policies, policy)
+	}
+	msgIds := make([]uint32, 0, len(m.msgs))
+	for msgId := range m.msgs {
+		msgIds = append(msgIds, msgId)
+	}
+	m.lock.RUnlock()
+	sort.Strings(reasons)
+	sort.Strings(policies)
+	sort.Slice(msgIds, func(i, j int) bool { return msgIds[i] < msgIds[j] })
+	// 按照MsgID的顺序输出，最后输出没有注册Router的MsgID
+	msgs := make([]labeledMsgMetrics, 0, len(msgIds)+1)
+	for _, msgId := range msgIds {
+		msgs = append(msgs, labeledMsgMetrics{strconv.FormatUint(uint64(msgId), 10), m.getMsg(msgId)})
+	}
+	msgs = append(msgs, labeledMsgMetrics{unknownMsgLabel, m.unknownMsgs})
+
+	writeHeader("zinx_connections_rejected_total", "counter", "Total number of rejected connections by reason.")
+	for _, reason := range reasons {
+		fmt.Fprintf(bw, "zinx_connections_rejected_total{reason=%q} %d\n", reason, atomic.LoadUint64(m.getCounter(m.rejectedConns, reason)))
+	}
+
+	writeHeader("zinx_task_queue_overload_total", "counter", "Total number of requests handled by policy when the task queue is full.")
+	for _, policy := range policies {
+		fmt.Fprintf(bw, "zinx_task_queue_overload_total{policy=%q} %d\n", policy, atomic.LoadUint64(m.getCounter(m.overloads, policy)))
+	}
+
+	writeHeader("zinx_task_queue_depth", "gauge", "Number of requests waiting in each task queue.")
+	for queueId, n := range taskQueueLens {
+		fmt.Fprintf(bw, "zinx_task_queue_depth{queue=\"%d\"} %d\n", queueId, n)
+	}
+
+	counters := []struct {
+		name string
+		help string
+		get  func(mm *msgMetrics) uint64
+	}{
+		{"zinx_messages_received_total", "Total number of received messages by msg id.", func(mm *msgMetrics) uint64 { return atomic.LoadUint64(&mm.msgsIn) }},
+		{"zinx_received_bytes_total", "Total number of received bytes by msg id.", func(mm *msgMetrics) uint64 { return atomic.LoadUint64(&mm.bytesIn) }},
+		{"zinx_messages_sent_total", "Total number of sent messages by msg id.", func(mm *msgMetrics) uint64 { return atomic.LoadUint64(&mm.msgsOut) }},
+		{"zinx_sent_bytes_total", "Total number of sent bytes by msg id.", func(mm *msgMetrics) uint64 { return atomic.LoadUint64(&mm.bytesOut) }},
+	}
+	for _, counter := range counters {
+		writeHeader(counter.name, "counter", counter.help)
+		for _, msg := range msgs {
+			fmt.Fprintf(bw, "%s{msg_id=%q} %d\n", counter.name, msg.label, counter.get(msg.mm))
+		}
+	}
+
+	writeHeader("zinx_handler_duration_seconds", "histogram", "Handler latency by msg id.")
+	for _, msg := range msgs {
+		mm := msg.mm
+		counts := make([]uint64, len(latencyBuckets)+1)
+		var cumulative uint64
+		for i := range latencyBuckets {
+			cumulative += atomic.LoadUint64(&mm.latency[i])
+			counts[i] = cumulative
+		}
+		cumulative += atomic.LoadUint64(&mm.latencyInf)
+		counts[len(latencyBuckets)] = cumulative
+		// 只收发过、没有经过业务处理的MsgID（例如服务器推送的消息）不输出直方图
+		if cumulative == 0 {
+			continue
+		}
+
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(bw, "zinx_handler_duration_seconds_bucket{msg_id=%q,le=\"%s\"} %d\n",
+				msg.label, strconv.FormatFloat(le, 'g', -1, 64), counts[i])
+		}
+		fmt.Fprintf(bw, "zinx_handler_duration_seconds_bucket{msg_id=%q,le=\"+Inf\"} %d\n", msg.label, cumulative)
+		fmt.Fprintf(bw, "zinx_handler_duration_seconds_sum{msg_id=%q} %s\n",
+			msg.label, strconv.FormatFloat(time.Duration(atomic.LoadUint64(&mm.latencySum)).Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "zinx_handler_duration_seconds_count{msg_id=%q} %d\n", msg.label, cumulative)
+	}
+}
+
+// newMetricsServer 创建以Prometheus文本格式提供统计数据的HTTP服务
+func (s *Server) newMetricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.Metrics.WritePrometheus(w, s.MsgHandler.GetTaskQueueLens())
+	})
+
+	return &http.Server{
+		Handler: mux,
+	}
+}
+
+// serveMetrics 在已经监听的本地端口上提供统计数据，直到服务被关闭
+func (s *Server) serveMetrics(httpServer *http.Server, listener net.Listener) {
+	zlog.Info("Start Zinx metrics server", zlog.Any("addr", listener.Addr().String()+"/metrics"))
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		zlog.Error("Metrics serve error", zlog.Err(err))
+	}
+}
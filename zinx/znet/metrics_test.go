@@ -0,0 +1,110 @@
+package znet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// 统计数据以Prometheus文本格式输出，直方图的区间是累计的
+func TestMetrics_WritePrometheus(t *testing.T) {
+	m := NewMetrics()
+	m.isRouted = func(msgId uint32) bool { return msgId == 3 }
+	m.ConnAccepted()
+	m.ConnOpened()
+	m.ConnRejected(RejectReasonMaxConn)
+	m.MsgReceived(3, 20)
+	m.MsgReceived(3, 20)
+	m.MsgSent(200, 30)
+	m.ObserveLatency(3, 2*time.Millisecond)
+	m.ObserveLatency(3, 10*time.Second)
+	// 没有注册Router的MsgID统计在一起
+	for msgId := uint32(1000); msgId < 1010; msgId++ {
+		m.MsgReceived(msgId, 10)
+		m.ObserveLatency(msgId, time.Millisecond)
+	}
+	m.MsgReceived(HeartbeatMsgID, 8)
+
+	buf := bytes.NewBuffer(nil)
+	m.WritePrometheus(buf, []int{0, 5})
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE zinx_connections_active gauge\nzinx_connections_active 1\n",
+		"zinx_connections_accepted_total 1\n",
+		`zinx_connections_rejected_total{reason="max_conn"} 1` + "\n",
+		`zinx_task_queue_depth{queue="1"} 5` + "\n",
+		`zinx_messages_received_total{msg_id="3"} 2` + "\n",
+		`zinx_received_bytes_total{msg_id="3"} 40` + "\n",
+		`zinx_sent_bytes_total{msg_id="200"} 30` + "\n",
+		`zinx_handler_duration_seconds_bucket{msg_id="3",le="0.001"} 0` + "\n",
+		`zinx_handler_duration_seconds_bucket{msg_id="3",le="0.005"} 1` + "\n",
+		`zinx_handler_duration_seconds_bucket{msg_id="3",le="5"} 1` + "\n",
+		`zinx_handler_duration_seconds_bucket{msg_id="3",le="+Inf"} 2` + "\n",
+		`zinx_handler_duration_seconds_sum{msg_id="3"} 10.002` + "\n",
+		`zinx_handler_duration_seconds_count{msg_id="3"} 2` + "\n",
+		`zinx_messages_received_total{msg_id="unknown"} 10` + "\n",
+		`zinx_handler_duration_seconds_count{msg_id="unknown"} 10` + "\n",
+		`zinx_messages_received_total{msg_id="65535"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+	if strings.Contains(out, `zinx_handler_duration_seconds_count{msg_id="200"}`) {
+		t.Error("msg id without handler should not have a histogram")
+	}
+	if strings.Contains(out, `msg_id="1000"`) {
+		t.Error("msg id without router should not have its own series")
+	}
+}
+
+// 同一个进程中的每个Server只统计自己的数据，客户端处理服务器的回复不计入Server的统计
+func TestMetrics_PerServer(t *testing.T) {
+	port := freePort(t)
+	game := NewServer(WithAddress("127.0.0.1", port)).(*Server)
+	game.AddRouter(1, &echoRouter{})
+	if err := game.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer game.Stop()
+	admin := NewServer(WithAddress("127.0.0.1", freePort(t))).(*Server)
+	if err := admin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Stop()
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	router := &replyRouter{replies: make(chan string, 1)}
+	c.AddRouter(1, router)
+	connected := make(chan struct{}, 1)
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		connected <- struct{}{}
+	})
+	c.Start()
+	defer c.Stop()
+	<-connected
+	if err := c.SendMsg(1, []byte("ping")); err != nil {
+		t.Fatal("Client send error:", err)
+	}
+	select {
+	case <-router.replies:
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive reply")
+	}
+
+	output := func(s *Server) string {
+		buf := bytes.NewBuffer(nil)
+		s.Metrics.WritePrometheus(buf, nil)
+		return buf.String()
+	}
+	if out := output(game); !strings.Contains(out, "zinx_connections_active 1\n") ||
+		!strings.Contains(out, `zinx_handler_duration_seconds_count{msg_id="1"} 1`+"\n") {
+		t.Errorf("game metrics:\n%s", out)
+	}
+	if out := output(admin); !strings.Contains(out, "zinx_connections_active 0\n") || strings.Contains(out, `msg_id="1"`) {
+		t.Errorf("admin metrics should not include game traffic:\n%s", out)
+	}
+}
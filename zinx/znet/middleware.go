@@ -0,0 +1,20 @@
+package znet
+
+import (
+	"time"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// LogMiddleware 记录每个请求的ConnID、MsgID以及处理耗时的中间件
+func LogMiddleware(next ziface.MsgHandleFunc) ziface.MsgHandleFunc {
+	return func(request ziface.IRequest) {
+		start := time.Now()
+		next(request)
+		zlog.Info("Handle request",
+			zlog.ConnID(request.GetConnection().GetConnID()),
+			zlog.MsgID(request.GetMsgID()),
+			zlog.Any("dataLen", len(request.GetData())),
+			zlog.Any("cost", time.Since(start)))
+	}
+}
@@ -2,31 +2,109 @@ package znet
 
 import (
 	"fmt"
+	"runtime/debug"
 	"strconv"
-	"zinx/utils"
+	"sync"
+	"sync/atomic"
+	"time"
 	"zinx/ziface"
+	"zinx/zlog"
 )
 
+// workerBatchSize Worker每次处理同一个消息队列的最大请求数
+const workerBatchSize = 16
+
 // MsgHandler 消息处理模块的实现
 type MsgHandler struct {
-	APIs           map[uint32]ziface.IRouter // 存放每个MsgID所对应的处理方法
-	TaskQueue      []chan ziface.IRequest    // 负责Worker取任务的消息队列
-	WorkerPoolSize uint32                    // 业务工作Worker池中的Worker数量
+	APIs              map[uint32]ziface.IRouter      // 存放每个MsgID所对应的处理方法
+	middlewares       []ziface.Middleware            // 全局中间件，对所有消息生效
+	routeMiddlewares  map[uint32][]ziface.Middleware // 每个MsgID所对应的中间件
+	TaskQueue         []chan ziface.IRequest         // 负责Worker取任务的消息队列
+	WorkerPoolSize    uint32                         // 消息队列的数量，也是业务工作Worker池中初始的Worker数量
+	TaskQueueLen      uint32                         // 每个消息队列的长度
+	dispatcher        ziface.IDispatcher             // 决定请求交给哪个消息队列的分发策略
+	scheduled         []int32                        // 每个消息队列是否已经交给了Worker，原子操作
+	readyChan         chan int                       // 等待Worker处理的消息队列编号
+	logicQueue        chan ziface.IRequest           // 由一个专门的Goroutine按顺序处理的逻辑队列
+	workerIDGen       uint32                         // 用来生成WorkerID的计数器，原子操作
+	workerNum         int32                          // 当前Worker的数量，原子操作
+	busyWorkers       int32                          // 正在处理请求的Worker数量，原子操作
+	minWorkers        uint32                         // 自动伸缩时Worker的最小数量
+	maxWorkers        uint32                         // 自动伸缩时Worker的最大数量，为0则不自动伸缩
+	autoscaleInterval time.Duration                  // 自动伸缩检查的时间间隔
+	retireChan        chan struct{}                  // 通知一个空闲的Worker退出的channel
+	exitChan          chan struct{}                  // 告知所有Worker退出的channel
+	panicHandler      ziface.PanicHandler            // 业务处理发生panic之后的处理策略
+	panicCount        uint64                         // 已经恢复的panic次数，原子操作
+	metrics           *Metrics                       // 记录业务处理耗时的统计模块，为nil则不统计（例如客户端）
+	stopOnce          sync.Once                      // 保证Worker工作池只会被停止一次
 }
 
+// NewMsgHandler 初始化消息处理模块，不开启Worker工作池，发生panic之后继续处理后续的请求
 func NewMsgHandler() *MsgHandler {
+	return NewMsgHandlerWithPool(0, 0, PanicPolicyContinue)
+}
+
+// NewMsgHandlerWithPool 初始化消息处理模块，Worker工作池中有workerPoolSize个Worker，
+// 每个Worker的消息队列长度为taskQueueLen，panicPolicy为业务处理发生panic之后的处理策略
+func NewMsgHandlerWithPool(workerPoolSize, taskQueueLen uint32, panicPolicy string) *MsgHandler {
 	return &MsgHandler{
-		APIs:           make(map[uint32]ziface.IRouter),
-		WorkerPoolSize: utils.GlobalObject.WorkerPoolSize, // 从全局配置中获取
-		TaskQueue:      make([]chan ziface.IRequest, utils.GlobalObject.WorkerPoolSize),
+		APIs:             make(map[uint32]ziface.IRouter),
+		routeMiddlewares: make(map[uint32][]ziface.Middleware),
+		WorkerPoolSize:   workerPoolSize,
+		TaskQueueLen:     taskQueueLen,
+		TaskQueue:        make([]chan ziface.IRequest, workerPoolSize),
+		dispatcher:       &ConnDispatcher{},
+		retireChan:       make(chan struct{}),
+		exitChan:         make(chan struct{}),
+		panicHandler:     NewPanicHandler(panicPolicy),
 	}
 }
 
 func (m *MsgHandler) DoMsgHandle(request ziface.IRequest) {
+	// 0、处理完毕之后通知请求所属的连接
+	if req, ok := request.(*Request); ok {
+		defer req.done()
+	}
+	// 记录业务处理（包括中间件）的耗时
+	defer func(start time.Time) {
+		m.metrics.ObserveLatency(request.GetMsgID(), time.Since(start))
+	}(time.Now())
+	// 恢复业务处理（包括中间件）中发生的panic，避免Worker或者整个进程退出
+	defer func() {
+		if err := recover(); err != nil {
+			m.handlePanic(request, err)
+		}
+	}()
+
+	// 1、由中间件依次包装对应的Router业务，全局中间件在最外层，先添加的中间件先执行
+	var handle ziface.MsgHandleFunc = m.handleRouter
+	routeMiddlewares := m.routeMiddlewares[request.GetMsgID()]
+	for i := len(routeMiddlewares) - 1; i >= 0; i-- {
+		handle = routeMiddlewares[i](handle)
+	}
+	for i := len(m.middlewares) - 1; i >= 0; i-- {
+		handle = m.middlewares[i](handle)
+	}
+
+	// 2、执行中间件及Router业务
+	handle(request)
+}
+
+// handleRouter 根据MsgID调度对应的Router业务
+func (m *MsgHandler) handleRouter(request ziface.IRequest) {
 	// 1、从Request中找到MsgID
 	handler, ok := m.APIs[request.GetMsgID()]
 	if !ok {
-		fmt.Println("API NOT FOUND! MsgID =", request.GetMsgID())
+		zlog.Warn("API NOT FOUND", zlog.MsgID(request.GetMsgID()))
+		// 告知客户端请求没有被处理，RPC请求可以立即返回错误而不是等待超时
+		if req, ok := request.(*Request); ok {
+			if conn, ok := req.conn.(*Connection); ok {
+				if err := conn.sendError(req.msg.GetSeq(), ErrCodeUnknownMsgID, ErrCodeUnknownMsgID.String()); err != nil {
+					zlog.Debug("Send error frame error", zlog.ConnID(conn.ConnID), zlog.Err(err))
+				}
+			}
+		}
 		return
 	}
 	// 2、根据MsgID调度对应的Router业务即可
@@ -35,6 +113,32 @@ func (m *MsgHandler) DoMsgHandle(request ziface.IRequest) {
 	handler.PostHandle(request)
 }
 
+// handlePanic 记录业务处理中发生的panic，再交给panicHandler决定后续的处理
+func (m *MsgHandler) handlePanic(request ziface.IRequest, err interface{}) {
+	atomic.AddUint64(&m.panicCount, 1)
+
+	var connId uint32
+	if conn := request.GetConnection(); conn != nil {
+		connId = conn.GetConnID()
+	}
+	zlog.Error("Handle panic recovered",
+		zlog.ConnID(connId),
+		zlog.MsgID(request.GetMsgID()),
+		zlog.Any("panic", fmt.Sprint(err)),
+		zlog.Any("stack", string(debug.Stack())))
+
+	m.panicHandler(request, err)
+}
+
+// SetPanicHandler 设置业务处理发生panic之后的处理策略，需要在Server启动之前调用
+func (m *MsgHandler) SetPanicHandler(handler ziface.PanicHandler) {
+	m.panicHandler = handler
+}
+
+func (m *MsgHandler) GetPanicCount() uint64 {
+	return atomic.LoadUint64(&m.panicCount)
+}
+
 func (m *MsgHandler) AddRouter(msgId uint32, router ziface.IRouter) {
 	// 1、判断当前msg绑定的API处理方法是否已经存在
 	if _, ok := m.APIs[msgId]; ok {
@@ -42,45 +146,299 @@ func (m *MsgHandler) AddRouter(msgId uint32, router ziface.IRouter) {
 	}
 	// 2、添加msg与API的绑定关系
 	m.APIs[msgId] = router
-	fmt.Println("Add API success, MsgID =", msgId)
+	zlog.Debug("Add API success", zlog.MsgID(msgId))
+}
+
+// hasRouter 判断MsgID是否注册了Router
+func (m *MsgHandler) hasRouter(msgId uint32) bool {
+	_, ok := m.APIs[msgId]
+	return ok
+}
+
+// Use 添加全局中间件，需要在Server启动之前调用
+func (m *MsgHandler) Use(middlewares ...ziface.Middleware) {
+	m.middlewares = append(m.middlewares, middlewares...)
+}
+
+// UseRouter 为指定MsgID添加中间件，需要在Server启动之前调用
+func (m *MsgHandler) UseRouter(msgId uint32, middlewares ...ziface.Middleware) {
+	m.routeMiddlewares[msgId] = append(m.routeMiddlewares[msgId], middlewares...)
+}
+
+// SetDispatcher 设置开启Worker工作池时的分发策略，需要在StartWorkerPool之前调用
+func (m *MsgHandler) SetDispatcher(dispatcher ziface.IDispatcher) {
+	m.dispatcher = dispatcher
+}
+
+// SetAutoscale 开启Worker数量的自动伸缩，需要在StartWorkerPool之前调用
+// 消息队列的数量扩大为maxWorkers，每隔interval根据消息队列的积压情况在minWorkers和maxWorkers之间调整Worker的数量
+func (m *MsgHandler) SetAutoscale(minWorkers, maxWorkers uint32, interval time.Duration) {
+	if minWorkers == 0 {
+		minWorkers = 1
+	}
+	m.minWorkers, m.maxWorkers, m.autoscaleInterval = minWorkers, maxWorkers, interval
+	if uint32(len(m.TaskQueue)) < maxWorkers {
+		m.TaskQueue = make([]chan ziface.IRequest, maxWorkers)
+	}
 }
 
 // StartWorkerPool 启动一个Worker工作池（开启工作池的动作只能发生一次，一个Zinx框架只能有一个Worker工作池）
+// 每个消息队列同一时间只会被一个Worker处理，保证同一个消息队列中的请求按顺序处理
 func (m *MsgHandler) StartWorkerPool() {
-	// 根据WorkerPoolSize分别开启Worker，每个Worker用一个Goroutine来承载
-	for i := 0; i < int(m.WorkerPoolSize); i++ {
-		// 1、当前的Worker对应的channel消息队列开辟空间
-		m.TaskQueue[i] = make(chan ziface.IRequest, utils.GlobalObject.MaxWorkerPoolSize)
+	if m.WorkerPoolSize == 0 {
+		return
+	}
 
-		// 2、启动当前的Worker，阻塞等待消息从channel中传递进来
-		go m.startOneWorker(i, m.TaskQueue[i])
+	// 1、为每个消息队列开辟空间
+	m.scheduled = make([]int32, len(m.TaskQueue))
+	m.readyChan = make(chan int, len(m.TaskQueue))
+	for i := range m.TaskQueue {
+		m.TaskQueue[i] = make(chan ziface.IRequest, m.TaskQueueLen)
 	}
+	m.logicQueue = make(chan ziface.IRequest, m.TaskQueueLen)
 
+	// 2、启动Worker，每个Worker用一个Goroutine来承载，阻塞等待有请求的消息队列
+	workerNum := m.WorkerPoolSize
+	if m.maxWorkers > 0 {
+		if workerNum < m.minWorkers {
+			workerNum = m.minWorkers
+		}
+		if workerNum > m.maxWorkers {
+			workerNum = m.maxWorkers
+		}
+		go m.autoscale()
+	}
+	for i := uint32(0); i < workerNum; i++ {
+		m.addWorker()
+	}
+
+	// 3、启动专门处理逻辑队列的Goroutine
+	go m.startLogicWorker()
+}
+
+// StopWorkerPool 停止Worker工作池，所有Worker在处理完当前的请求之后退出
+func (m *MsgHandler) StopWorkerPool() {
+	m.stopOnce.Do(func() {
+		close(m.exitChan)
+	})
+}
+
+// addWorker 增加一个Worker
+func (m *MsgHandler) addWorker() {
+	workerId := int(atomic.AddUint32(&m.workerIDGen, 1) - 1)
+	atomic.AddInt32(&m.workerNum, 1)
+	go m.startOneWorker(workerId)
 }
 
 // startOneWorker 启动一个Worker工作流程
-func (m *MsgHandler) startOneWorker(workerId int, taskQueue chan ziface.IRequest) {
-	fmt.Println("WorkerID =", workerId, "is starting...")
+func (m *MsgHandler) startOneWorker(workerId int) {
+	zlog.Debug("Worker is starting", zlog.Any("workerID", workerId))
 
-	// 不断阻塞等待对应消息队列的消息
+	// Worker意外退出时重新启动，避免消息队列没有Worker消费而被填满
+	defer func() {
+		if err := recover(); err != nil {
+			if _, ok := err.(crashPanic); ok {
+				panic(err)
+			}
+			zlog.Error("Worker panic, restarting", zlog.Any("workerID", workerId), zlog.Any("panic", fmt.Sprint(err)))
+			go m.startOneWorker(workerId)
+		}
+	}()
+
+	// 不断阻塞等待有请求的消息队列
 	for {
 		select {
-		// 如果有消息过来，出队的就是一个客户端Request，执行当前Request所绑定业务
+		// 有请求的消息队列，依次处理其中的请求
+		case queueId := <-m.readyChan:
+			m.runTaskQueue(queueId)
+		// Worker数量缩减，空闲的Worker退出
+		case <-m.retireChan:
+			atomic.AddInt32(&m.workerNum, -1)
+			zlog.Debug("Worker retired", zlog.Any("workerID", workerId))
+			return
+		// Worker工作池已经停止，Worker退出
+		case <-m.exitChan:
+			zlog.Debug("Worker exit", zlog.Any("workerID", workerId))
+			return
+		}
+	}
+}
+
+// runTaskQueue 处理一个消息队列中的请求，最多处理workerBatchSize个请求之后让出，避免其他消息队列等待过久
+func (m *MsgHandler) runTaskQueue(queueId int) {
+	atomic.AddInt32(&m.busyWorkers, 1)
+	defer atomic.AddInt32(&m.busyWorkers, -1)
+	// 即使业务处理发生panic也需要释放消息队列
+	defer m.releaseTaskQueue(queueId)
+
+	taskQueue := m.TaskQueue[queueId]
+	for i := 0; i < workerBatchSize; i++ {
+		select {
 		case request := <-taskQueue:
 			m.DoMsgHandle(request)
+		default:
+			return
+		}
+	}
+}
+
+// scheduleTaskQueue 消息队列中有新的请求，没有Worker在处理该消息队列时交给空闲的Worker
+func (m *MsgHandler) scheduleTaskQueue(queueId int) {
+	if atomic.CompareAndSwapInt32(&m.scheduled[queueId], 0, 1) {
+		// 每个消息队列最多只在readyChan中出现一次，不会阻塞
+		m.readyChan <- queueId
+	}
+}
+
+// releaseTaskQueue Worker不再处理该消息队列，消息队列中还有请求时重新交给空闲的Worker
+func (m *MsgHandler) releaseTaskQueue(queueId int) {
+	atomic.StoreInt32(&m.scheduled[queueId], 0)
+	if len(m.TaskQueue[queueId]) > 0 {
+		m.scheduleTaskQueue(queueId)
+	}
+}
+
+// startLogicWorker 启动处理逻辑队列的Goroutine，逻辑队列中的请求全部在这一个Goroutine中按顺序处理
+func (m *MsgHandler) startLogicWorker() {
+	defer func() {
+		if err := recover(); err != nil {
+			if _, ok := err.(crashPanic); ok {
+				panic(err)
+			}
+			zlog.Error("Logic worker panic, restarting", zlog.Any("panic", fmt.Sprint(err)))
+			go m.startLogicWorker()
+		}
+	}()
+
+	for {
+		select {
+		case request := <-m.logicQueue:
+			m.DoMsgHandle(request)
+		case <-m.exitChan:
+			return
+		}
+	}
+}
+
+// autoscale 每隔autoscaleInterval根据消息队列的积压情况调整Worker的数量
+// 有消息队列在等待Worker时增加Worker，空闲的Worker超过一半时减少一个Worker
+func (m *MsgHandler) autoscale() {
+	ticker := time.NewTicker(m.autoscaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.exitChan:
+			return
+		}
+
+		workerNum := uint32(m.GetWorkerNum())
+		waiting := uint32(len(m.readyChan))
+		busy := uint32(atomic.LoadInt32(&m.busyWorkers))
+		switch {
+		case waiting > 0 && workerNum < m.maxWorkers:
+			add := waiting
+			if add > m.maxWorkers-workerNum {
+				add = m.maxWorkers - workerNum
+			}
+			for i := uint32(0); i < add; i++ {
+				m.addWorker()
+			}
+			zlog.Debug("Worker pool scale up", zlog.Any("workerNum", workerNum+add))
+		case waiting == 0 && busy*2 < workerNum && workerNum > m.minWorkers:
+			// 只有空闲的Worker会收到退出的通知
+			select {
+			case m.retireChan <- struct{}{}:
+				zlog.Debug("Worker pool scale down", zlog.Any("workerNum", workerNum-1))
+			default:
+			}
 		}
 	}
 }
 
+// GetTaskQueueLens 获取每个消息队列中等待处理的请求数
+func (m *MsgHandler) GetTaskQueueLens() []int {
+	lens := make([]int, len(m.TaskQueue))
+	for i, taskQueue := range m.TaskQueue {
+		lens[i] = len(taskQueue)
+	}
+	return lens
+}
+
+func (m *MsgHandler) GetTaskQueueNum() int {
+	return len(m.TaskQueue)
+}
+
+func (m *MsgHandler) GetTaskQueueLen(queueId int) int {
+	return len(m.TaskQueue[queueId])
+}
+
+func (m *MsgHandler) GetWorkerNum() int {
+	return int(atomic.LoadInt32(&m.workerNum))
+}
+
+// GetSaturation 获取消息队列的饱和度：全部消息队列（包括逻辑队列）中等待处理的请求数占总容量的比例
+func (m *MsgHandler) GetSaturation() float64 {
+	if m.WorkerPoolSize == 0 || m.TaskQueueLen == 0 {
+		return 0
+	}
+	waiting := len(m.logicQueue)
+	for _, taskQueue := range m.TaskQueue {
+		waiting += len(taskQueue)
+	}
+	return float64(waiting) / float64((len(m.TaskQueue)+1)*int(m.TaskQueueLen))
+}
+
 // SendMsgToTaskQueue 将消息交给TaskQueue，由Worker进行处理
-func (m *MsgHandler) SendMsgToTaskQueue(request ziface.IRequest) {
-	// 1、将消息平均分配给不同的Worker
-	// 根据客户端建立的ConnID来进行分配
-	workerId := request.GetConnection().GetConnID() % m.WorkerPoolSize
-	fmt.Println("Add ConnID =", request.GetConnection().GetConnID(),
-		"message MsgID =", request.GetMsgID(),
-		"to WorkerID =", workerId)
-
-	// 2、将消息发送给对应的Worker的TaskQueue即可
-	m.TaskQueue[workerId] <- request
+// 消息队列已满时最多等待timeout，为0则不等待，小于0则一直等待，仍然无法放入时返回ErrTaskQueueFull
+func (m *MsgHandler) SendMsgToTaskQueue(request ziface.IRequest, timeout time.Duration) error {
+	// 1、由分发策略决定交给哪个消息队列，默认根据客户端建立的ConnID来进行分配
+	queueId := m.dispatcher.Dispatch(request, m.APIs[request.GetMsgID()], m)
+	if zlog.Enabled(zlog.DebugLevel) {
+		zlog.Debug("Add request to TaskQueue",
+			zlog.ConnID(request.GetConnection().GetConnID()),
+			zlog.MsgID(request.GetMsgID()),
+			zlog.Any("queueID", queueId))
+	}
+
+	var taskQueue chan ziface.IRequest
+	switch {
+	case queueId == ziface.LogicQueue:
+		taskQueue = m.logicQueue
+	case queueId < 0 || queueId >= len(m.TaskQueue):
+		zlog.Warn("Invalid task queue dispatched", zlog.MsgID(request.GetMsgID()), zlog.Any("queueID", queueId))
+		queueId = int(request.GetConnection().GetConnID() % uint32(len(m.TaskQueue)))
+		fallthrough
+	default:
+		taskQueue = m.TaskQueue[queueId]
+	}
+
+	// 2、将消息发送给对应的消息队列，已满时按照timeout等待
+	select {
+	case taskQueue <- request:
+	default:
+		if timeout == 0 {
+			return ErrTaskQueueFull
+		}
+		var timeoutChan <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			timeoutChan = timer.C
+		}
+		select {
+		case taskQueue <- request:
+		case <-timeoutChan:
+			return ErrTaskQueueFull
+		case <-m.exitChan:
+			return ErrWorkerPoolStopped
+		}
+	}
+
+	// 3、交给空闲的Worker，逻辑队列由专门的Goroutine处理
+	if queueId != ziface.LogicQueue {
+		m.scheduleTaskQueue(queueId)
+	}
+	return nil
 }
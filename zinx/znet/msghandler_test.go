@@ -0,0 +1,125 @@
+package znet
+
+import (
+	"reflect"
+	"testing"
+	"zinx/ziface"
+)
+
+// recordRouter 记录Router业务被调用的顺序
+type recordRouter struct {
+	BaseRouter
+	trace *[]string
+}
+
+func (r *recordRouter) Handle(request ziface.IRequest) {
+	*r.trace = append(*r.trace, "handle")
+}
+
+// 中间件的执行顺序：全局中间件在最外层，先添加的先执行；不调用next可以中断请求
+func TestMsgHandler_Use(t *testing.T) {
+	var trace []string
+	record := func(name string) ziface.Middleware {
+		return func(next ziface.MsgHandleFunc) ziface.MsgHandleFunc {
+			return func(request ziface.IRequest) {
+				trace = append(trace, name+" before")
+				next(request)
+				trace = append(trace, name+" after")
+			}
+		}
+	}
+	deny := func(next ziface.MsgHandleFunc) ziface.MsgHandleFunc {
+		return func(request ziface.IRequest) {
+			trace = append(trace, "deny")
+		}
+	}
+
+	m := NewMsgHandler()
+	m.AddRouter(1, &recordRouter{trace: &trace})
+	m.AddRouter(2, &recordRouter{trace: &trace})
+	m.UseRouter(1, record("route"))
+	m.UseRouter(2, deny)
+	m.Use(record("global1"), record("global2"))
+
+	m.DoMsgHandle(&Request{msg: NewMessage(1, nil)})
+	want := []string{
+		"global1 before", "global2 before", "route before",
+		"handle",
+		"route after", "global2 after", "global1 after",
+	}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("trace = %v, want %v", trace, want)
+	}
+
+	trace = nil
+	m.DoMsgHandle(&Request{msg: NewMessage(2, nil)})
+	want = []string{"global1 before", "global2 before", "deny", "global2 after", "global1 after"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("trace = %v, want %v", trace, want)
+	}
+}
+
+// propertyRouter 记录中间件设置的请求属性
+type propertyRouter struct {
+	BaseRouter
+	user interface{}
+}
+
+func (r *propertyRouter) Handle(request ziface.IRequest) {
+	r.user, _ = request.GetProperty("user")
+}
+
+// 中间件可以通过请求属性把鉴权的结果传递给Router业务
+func TestMsgHandler_RequestProperty(t *testing.T) {
+	m := NewMsgHandler()
+	router := &propertyRouter{}
+	m.AddRouter(1, router)
+	m.Use(func(next ziface.MsgHandleFunc) ziface.MsgHandleFunc {
+		return func(request ziface.IRequest) {
+			request.SetProperty("user", "alice")
+			next(request)
+		}
+	})
+
+	m.DoMsgHandle(&Request{msg: NewMessage(1, nil)})
+	if router.user != "alice" {
+		t.Errorf("property user = %v, want alice", router.user)
+	}
+	if _, err := (&Request{}).GetProperty("user"); err == nil {
+		t.Error("property of another request should not be found")
+	}
+}
+
+// panicRouter 模拟一个发生panic的业务
+type panicRouter struct {
+	BaseRouter
+}
+
+func (r *panicRouter) Handle(request ziface.IRequest) {
+	var player *struct{ ID int32 }
+	_ = player.ID
+}
+
+// 业务发生panic时不会影响调用方，panic次数会被统计，并交给panicHandler处理
+func TestMsgHandler_Panic(t *testing.T) {
+	m := NewMsgHandler()
+	m.AddRouter(1, &panicRouter{})
+
+	var handled []uint32
+	m.SetPanicHandler(func(request ziface.IRequest, recovered interface{}) {
+		handled = append(handled, request.GetMsgID())
+	})
+
+	done := false
+	m.DoMsgHandle(&Request{msg: NewMessage(1, nil), onDone: func() { done = true }})
+
+	if n := m.GetPanicCount(); n != 1 {
+		t.Error("panic count =", n, "want 1")
+	}
+	if !reflect.DeepEqual(handled, []uint32{1}) {
+		t.Error("panic handler called with", handled)
+	}
+	if !done {
+		t.Error("request should be done after panic")
+	}
+}
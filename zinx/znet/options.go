@@ -0,0 +1,145 @@
+package znet
+
+import (
+	"crypto/tls"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+// Option 创建Server时的可选配置
+type Option func(s *Server)
+
+// setConfig 修改当前Server的配置，并记录被修改的配置项，重新加载配置时这些配置项保持不变
+// 只能在NewServer中调用，此时Server的配置还是自己的一份副本
+func (s *Server) setConfig(name string, set func(conf *utils.GlobalObj)) {
+	set(s.GetConfig())
+	s.overrides = append(s.overrides, name)
+	s.overrideSetters = append(s.overrideSetters, set)
+}
+
+// WithConfig 使用conf的一份副本作为当前Server的配置，不再跟随utils.Reload更新
+// 与其他修改配置的Option的顺序无关，之前的Option对配置的修改会重新应用到新的配置上
+func WithConfig(conf *utils.GlobalObj) Option {
+	return func(s *Server) {
+		c := *conf
+		for _, set := range s.overrideSetters {
+			set(&c)
+		}
+		s.config.Store(&c)
+		s.followReload = false
+	}
+}
+
+// WithName 设置服务器的名称
+func WithName(name string) Option {
+	return func(s *Server) {
+		s.setConfig("name", func(conf *utils.GlobalObj) {
+			conf.Name = name
+		})
+	}
+}
+
+// WithAddress 设置服务器监听的IP和端口
+func WithAddress(ip string, port int) Option {
+	return func(s *Server) {
+		s.setConfig("ip", func(conf *utils.GlobalObj) {
+			conf.IP = ip
+		})
+		s.setConfig("port", func(conf *utils.GlobalObj) {
+			conf.Port = port
+		})
+	}
+}
+
+// WithTransport 设置服务器监听使用的传输协议：tcp、kcp
+func WithTransport(transport string) Option {
+	return func(s *Server) {
+		s.setConfig("transport", func(conf *utils.GlobalObj) {
+			conf.Transport = transport
+		})
+	}
+}
+
+// WithTLSConfig 设置TCP及WebSocket监听使用的TLS配置
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(s *Server) {
+		s.TLSConfig = tlsConfig
+	}
+}
+
+// WithEncryption 设置是否开启会话加密，开启之后客户端需要在连接之后先进行密钥交换
+func WithEncryption(enable bool) Option {
+	return func(s *Server) {
+		s.setConfig("encryption", func(conf *utils.GlobalObj) {
+			conf.Encryption = enable
+		})
+	}
+}
+
+// WithCodec 设置封包拆包模块
+func WithCodec(dataPack ziface.IDataPack) Option {
+	return func(s *Server) {
+		s.DataPack = dataPack
+	}
+}
+
+// WithWorkerPool 设置Worker工作池中Worker的数量及每个Worker的消息队列长度，size为0则不开启Worker工作池
+func WithWorkerPool(size, taskQueueLen uint32) Option {
+	return func(s *Server) {
+		s.setConfig("worker_pool_size", func(conf *utils.GlobalObj) {
+			conf.WorkerPoolSize = size
+		})
+		s.setConfig("max_worker_pool_size", func(conf *utils.GlobalObj) {
+			conf.MaxWorkerPoolSize = taskQueueLen
+		})
+	}
+}
+
+// WithAutoscale 开启Worker数量的自动伸缩，Worker的数量在minWorkers和maxWorkers之间调整
+func WithAutoscale(minWorkers, maxWorkers uint32) Option {
+	return func(s *Server) {
+		s.setConfig("autoscale_min_workers", func(conf *utils.GlobalObj) {
+			conf.AutoscaleMinWorkers = minWorkers
+		})
+		s.setConfig("autoscale_max_workers", func(conf *utils.GlobalObj) {
+			conf.AutoscaleMaxWorkers = maxWorkers
+		})
+	}
+}
+
+// WithDispatcher 设置开启Worker工作池时的分发策略，代替配置中的dispatch_mode
+func WithDispatcher(dispatcher ziface.IDispatcher) Option {
+	return func(s *Server) {
+		s.dispatcher = dispatcher
+	}
+}
+
+// WithMaxConn 设置服务器允许的最大连接数
+func WithMaxConn(maxConn int) Option {
+	return func(s *Server) {
+		s.setConfig("max_conn", func(conf *utils.GlobalObj) {
+			conf.MaxConn = maxConn
+		})
+	}
+}
+
+// WithOnConnStart 设置创建连接之后调用的Hook函数
+func WithOnConnStart(hookFunc func(conn ziface.IConnection)) Option {
+	return func(s *Server) {
+		s.OnConnStart = hookFunc
+	}
+}
+
+// WithOnConnStop 设置连接断开之前调用的Hook函数
+func WithOnConnStop(hookFunc func(conn ziface.IConnection)) Option {
+	return func(s *Server) {
+		s.OnConnStop = hookFunc
+	}
+}
+
+// WithOnConnDead 设置连接空闲超时被认为失效时调用的Hook函数
+func WithOnConnDead(hookFunc func(conn ziface.IConnection)) Option {
+	return func(s *Server) {
+		s.OnConnDead = hookFunc
+	}
+}
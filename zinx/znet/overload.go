@@ -0,0 +1,59 @@
+package znet
+
+import (
+	"errors"
+	"time"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// 消息队列已满时的处理策略
+const (
+	TaskQueuePolicyBlock      = "block"      // 等待消息队列有空位，超过task_queue_timeout之后丢弃
+	TaskQueuePolicyDrop       = "drop"       // 丢弃当前的请求
+	TaskQueuePolicyBusy       = "busy"       // 丢弃当前的请求，并给客户端回复服务器繁忙的错误消息
+	TaskQueuePolicyDisconnect = "disconnect" // 断开发送请求的客户端
+)
+
+var (
+	ErrTaskQueueFull     = errors.New("task queue is full")
+	ErrWorkerPoolStopped = errors.New("worker pool is stopped")
+)
+
+// sendToTaskQueue 将请求交给Worker工作池，消息队列已满时按照task_queue_policy处理，返回请求是否已经交给Worker工作池
+func (c *Connection) sendToTaskQueue(request *Request) bool {
+	conf := c.Server.GetConfig()
+
+	// 只有block策略需要等待，task_queue_timeout为0时一直等待
+	timeout := time.Duration(0)
+	if conf.TaskQueuePolicy == TaskQueuePolicyBlock || conf.TaskQueuePolicy == "" {
+		timeout = time.Duration(conf.TaskQueueTimeout) * time.Millisecond
+		if timeout == 0 {
+			timeout = -1
+		}
+	}
+	err := c.MsgHandler.SendMsgToTaskQueue(request, timeout)
+	if err == nil {
+		return true
+	}
+	c.metrics.TaskOverloaded(conf.TaskQueuePolicy)
+
+	switch conf.TaskQueuePolicy {
+	case TaskQueuePolicyBusy:
+		zlog.Debug("Task queue is full, reply server busy", zlog.ConnID(c.ConnID), zlog.MsgID(request.GetMsgID()))
+		if err := c.sendError(request.msg.GetSeq(), ErrCodeServerBusy, ErrCodeServerBusy.String()); err != nil {
+			zlog.Debug("Send error frame error", zlog.ConnID(c.ConnID), zlog.Err(err))
+		}
+	case TaskQueuePolicyDisconnect:
+		zlog.Warn("Task queue is full, disconnect", zlog.ConnID(c.ConnID), zlog.MsgID(request.GetMsgID()), zlog.RemoteAddr(c.Conn.RemoteAddr()))
+		c.Stop()
+	default:
+		zlog.Debug("Task queue is full, drop msg", zlog.ConnID(c.ConnID), zlog.MsgID(request.GetMsgID()), zlog.Err(err))
+	}
+	return false
+}
+
+// admit 准入控制：消息队列的饱和度达到admission_threshold时拒绝新的连接
+func admit(msgHandler ziface.IMsgHandler, threshold float64) bool {
+	return threshold <= 0 || msgHandler.GetSaturation() < threshold
+}
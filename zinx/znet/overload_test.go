@@ -0,0 +1,138 @@
+package znet
+
+import (
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+// blockRouter 阻塞到unblock被关闭，用于模拟处理不过来的Worker
+type blockRouter struct {
+	BaseRouter
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (r *blockRouter) Handle(request ziface.IRequest) {
+	r.started <- struct{}{}
+	<-r.unblock
+}
+
+// 消息队列已满时按照timeout返回ErrTaskQueueFull，Worker工作池停止之后返回ErrWorkerPoolStopped
+func TestMsgHandler_SendMsgToTaskQueueFull(t *testing.T) {
+	m := NewMsgHandlerWithPool(1, 1, PanicPolicyContinue)
+	router := &blockRouter{started: make(chan struct{}, 1), unblock: make(chan struct{})}
+	m.AddRouter(1, router)
+	m.StartWorkerPool()
+	defer close(router.unblock)
+
+	conn := &Connection{ConnID: 1}
+	newRequest := func() *Request {
+		return &Request{conn: conn, msg: NewMessage(1, nil)}
+	}
+	// 第一个请求正在处理，第二个请求填满消息队列
+	if err := m.SendMsgToTaskQueue(newRequest(), 0); err != nil {
+		t.Fatal(err)
+	}
+	<-router.started
+	if err := m.SendMsgToTaskQueue(newRequest(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.GetSaturation(); s != 0.5 {
+		t.Error("saturation =", s, "want 0.5")
+	}
+
+	if err := m.SendMsgToTaskQueue(newRequest(), 0); err != ErrTaskQueueFull {
+		t.Error("no wait: err =", err, "want ErrTaskQueueFull")
+	}
+	start := time.Now()
+	if err := m.SendMsgToTaskQueue(newRequest(), 20*time.Millisecond); err != ErrTaskQueueFull {
+		t.Error("wait: err =", err, "want ErrTaskQueueFull")
+	}
+	if cost := time.Since(start); cost < 20*time.Millisecond {
+		t.Error("returned before timeout:", cost)
+	}
+
+	m.StopWorkerPool()
+	if err := m.SendMsgToTaskQueue(newRequest(), -1); err != ErrWorkerPoolStopped {
+		t.Error("stopped: err =", err, "want ErrWorkerPoolStopped")
+	}
+}
+
+// busy策略给RPC请求回复服务器繁忙的错误，消息队列饱和之后拒绝新的连接
+func TestServer_TaskQueueBusy(t *testing.T) {
+	conf := utils.NewDefaultGlobalObj()
+	conf.TaskQueuePolicy = TaskQueuePolicyBusy
+	conf.AdmissionThreshold = 0.5
+	port := freePort(t)
+	s := NewServer(
+		WithConfig(conf),
+		WithAddress("127.0.0.1", port),
+		WithCodec(NewSeqDataPack()),
+		WithWorkerPool(1, 1),
+	).(*Server)
+	router := &blockRouter{started: make(chan struct{}, 1), unblock: make(chan struct{})}
+	s.AddRouter(1, router)
+	s.Start()
+	defer s.Stop()
+	defer close(router.unblock)
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.SetDataPack(NewSeqDataPack())
+	connected := make(chan struct{})
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		close(connected)
+	})
+	c.Start()
+	defer c.Stop()
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not connect")
+	}
+
+	// 第一个请求正在处理，第二个请求填满消息队列，第三个请求被拒绝
+	go c.Call(1, nil, time.Second)
+	<-router.started
+	go c.Call(1, nil, time.Second)
+	for i := 0; i < 50 && s.MsgHandler.GetSaturation() < 0.5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err := c.Call(1, nil, time.Second)
+	if serverErr, ok := err.(*ServerError); !ok || serverErr.Code != ErrCodeServerBusy {
+		t.Fatal("Call err =", err, "want server busy")
+	}
+
+	// 消息队列饱和时新的连接收到服务器繁忙的错误消息之后被关闭
+	conn := dialRetry(t, s.Addr().String())
+	defer conn.Close()
+	expectRejected(t, conn, s.GetDataPack(), ErrServerBusy)
+}
+
+// busy策略在Reader中回复错误消息，不等待Writer，发送队列已满时丢弃错误消息
+func TestConnection_BusyReplyNoWait(t *testing.T) {
+	c := &Connection{
+		Server:      NewServer(),
+		ConnID:      1,
+		msgChan:     make(chan []byte),
+		msgBuffChan: make(chan []byte, 1),
+	}
+	if err := c.sendError(7, ErrCodeServerBusy, ErrCodeServerBusy.String()); err != nil {
+		t.Fatal("sendError with free send buff:", err)
+	}
+
+	// Writer没有取走发送队列中的消息
+	done := make(chan error, 1)
+	go func() {
+		done <- c.sendError(8, ErrCodeServerBusy, ErrCodeServerBusy.String())
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("error frame should be dropped when send buff is full")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sendError blocked by a stalled writer")
+	}
+}
@@ -0,0 +1,50 @@
+package znet
+
+import (
+	"fmt"
+	"zinx/ziface"
+)
+
+// 业务处理发生panic之后的处理策略
+const (
+	PanicPolicyContinue  = "continue"   // 记录之后继续处理后续的请求
+	PanicPolicyCloseConn = "close_conn" // 关闭发起该请求的连接
+	PanicPolicyCrash     = "crash"      // 重新抛出panic，让进程退出
+)
+
+// crashPanic PanicCrash策略重新抛出的panic，Worker不会恢复它
+type crashPanic struct {
+	recovered interface{}
+}
+
+func (c crashPanic) Error() string {
+	return fmt.Sprint("zinx crash on panic: ", c.recovered)
+}
+
+// PanicContinue 忽略panic，继续处理后续的请求
+func PanicContinue(request ziface.IRequest, recovered interface{}) {
+}
+
+// PanicCloseConn 关闭发起该请求的连接
+func PanicCloseConn(request ziface.IRequest, recovered interface{}) {
+	if conn := request.GetConnection(); conn != nil {
+		conn.Stop()
+	}
+}
+
+// PanicCrash 重新抛出panic，让进程退出
+func PanicCrash(request ziface.IRequest, recovered interface{}) {
+	panic(crashPanic{recovered: recovered})
+}
+
+// NewPanicHandler 根据配置的策略名称得到对应的处理策略，未知的策略按照continue处理
+func NewPanicHandler(policy string) ziface.PanicHandler {
+	switch policy {
+	case PanicPolicyCloseConn:
+		return PanicCloseConn
+	case PanicPolicyCrash:
+		return PanicCrash
+	default:
+		return PanicContinue
+	}
+}
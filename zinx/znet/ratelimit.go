@@ -0,0 +1,208 @@
+package znet
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+	"zinx/zlog"
+)
+
+// 超出限流之后的处理策略
+const (
+	RateLimitActionDrop       = "drop"       // 丢弃超出限制的消息
+	RateLimitActionDelay      = "delay"      // 延迟读取，直到有令牌为止（对客户端形成反压）
+	RateLimitActionDisconnect = "disconnect" // 断开超出限制的客户端
+)
+
+// tokenBucket 令牌桶，以固定的速度生成令牌，最多存放burst个令牌
+type tokenBucket struct {
+	rate   float64    // 每秒生成的令牌数
+	burst  float64    // 令牌桶的容量
+	tokens float64    // 当前的令牌数，为负数时代表已经预支的令牌
+	last   time.Time  // 上一次生成令牌的时间
+	lock   sync.Mutex // 保护令牌桶的锁
+}
+
+// newTokenBucket 根据限流配置创建令牌桶，Rate为0时返回nil，代表不限流
+func newTokenBucket(conf utils.RateLimitConf) *tokenBucket {
+	if conf.Rate <= 0 {
+		return nil
+	}
+	burst := float64(conf.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(conf.Rate))
+	}
+	return &tokenBucket{
+		rate:   conf.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill 根据流逝的时间生成令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow 有令牌时取走一个令牌并返回true，没有令牌时返回false
+func (b *tokenBucket) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve 预支一个令牌，返回令牌生成之前需要等待的时间
+func (b *tokenBucket) reserve() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// connBuckets 一个连接的令牌桶
+type connBuckets struct {
+	conn *tokenBucket            // 连接的令牌桶
+	msgs map[uint32]*tokenBucket // 连接中每个MsgID的令牌桶
+}
+
+// RateLimiter IRateLimiter的接口实现，使用令牌桶分别限制所有连接合计、每个连接以及每个连接中指定MsgID的消息速度
+type RateLimiter struct {
+	Action    string                         // 超出限流之后的处理策略：drop、delay、disconnect
+	global    *tokenBucket                   // 所有连接共用的令牌桶
+	connConf  utils.RateLimitConf            // 每个连接的限流配置
+	msgConfs  map[uint32]utils.RateLimitConf // 每个连接中指定MsgID的限流配置
+	conns     map[uint32]*connBuckets        // 每个连接的令牌桶，key为ConnID
+	connsLock sync.Mutex                     // 保护连接令牌桶集合的锁
+}
+
+// NewRateLimiter 初始化RateLimiter模块
+func NewRateLimiter(global, conn utils.RateLimitConf, msgs map[uint32]utils.RateLimitConf, action string) *RateLimiter {
+	if action == "" {
+		action = RateLimitActionDrop
+	}
+	return &RateLimiter{
+		Action:   action,
+		global:   newTokenBucket(global),
+		connConf: conn,
+		msgConfs: msgs,
+		conns:    make(map[uint32]*connBuckets),
+	}
+}
+
+// newRateLimiterFromConfig 根据配置中的限流配置初始化限流模块，没有配置任何限流时返回nil
+func newRateLimiterFromConfig(g *utils.GlobalObj) ziface.IRateLimiter {
+	if g.GlobalRateLimit.Rate <= 0 && g.ConnRateLimit.Rate <= 0 && len(g.MsgRateLimits) == 0 {
+		return nil
+	}
+	return NewRateLimiter(g.GlobalRateLimit, g.ConnRateLimit, g.MsgRateLimits, g.RateLimitAction)
+}
+
+// Limit 依次检查MsgID、连接以及所有连接合计的令牌桶
+// delay策略预支令牌并返回需要等待的最长时间，其他策略在没有令牌时直接返回limited
+func (r *RateLimiter) Limit(conn ziface.IConnection, msgId uint32) (time.Duration, bool) {
+	buckets := r.getConnBuckets(conn.GetConnID())
+	checks := []*tokenBucket{buckets.msgs[msgId], buckets.conn, r.global}
+
+	if r.Action == RateLimitActionDelay {
+		var delay time.Duration
+		for _, bucket := range checks {
+			if bucket == nil {
+				continue
+			}
+			if wait := bucket.reserve(); wait > delay {
+				delay = wait
+			}
+		}
+		return delay, delay > 0
+	}
+
+	for _, bucket := range checks {
+		if bucket != nil && !bucket.allow() {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// getConnBuckets 获取连接的令牌桶，第一次获取时根据配置创建
+func (r *RateLimiter) getConnBuckets(connID uint32) *connBuckets {
+	r.connsLock.Lock()
+	defer r.connsLock.Unlock()
+
+	if buckets, ok := r.conns[connID]; ok {
+		return buckets
+	}
+	buckets := &connBuckets{
+		conn: newTokenBucket(r.connConf),
+		msgs: make(map[uint32]*tokenBucket, len(r.msgConfs)),
+	}
+	for msgId, conf := range r.msgConfs {
+		if bucket := newTokenBucket(conf); bucket != nil {
+			buckets.msgs[msgId] = bucket
+		}
+	}
+	r.conns[connID] = buckets
+	return buckets
+}
+
+func (r *RateLimiter) GetAction() string {
+	return r.Action
+}
+
+func (r *RateLimiter) RemoveConn(connID uint32) {
+	r.connsLock.Lock()
+	defer r.connsLock.Unlock()
+
+	delete(r.conns, connID)
+}
+
+// checkRateLimit 按照Server的限流模块检查收到的消息，返回该消息是否需要继续处理
+func (c *Connection) checkRateLimit(msgId uint32) bool {
+	limiter := c.Server.GetRateLimiter()
+	if limiter == nil {
+		return true
+	}
+	delay, limited := limiter.Limit(c, msgId)
+	if !limited {
+		return true
+	}
+	atomic.AddUint64(&c.rateLimitedCount, 1)
+
+	switch limiter.GetAction() {
+	case RateLimitActionDelay:
+		// 延迟读取后续的消息，连接开始停止时立即返回
+		select {
+		case <-time.After(delay):
+			return true
+		case <-c.stopChan:
+			return false
+		}
+	case RateLimitActionDisconnect:
+		zlog.Warn("Rate limit exceeded, disconnect", zlog.ConnID(c.ConnID), zlog.MsgID(msgId), zlog.RemoteAddr(c.Conn.RemoteAddr()))
+		c.closeWithError(ErrCodeRateLimited, ErrCodeRateLimited.String())
+		return false
+	default:
+		zlog.Debug("Rate limit exceeded, drop msg", zlog.ConnID(c.ConnID), zlog.MsgID(msgId))
+		return false
+	}
+}
+
+func (c *Connection) GetRateLimitedCount() uint64 {
+	return atomic.LoadUint64(&c.rateLimitedCount)
+}
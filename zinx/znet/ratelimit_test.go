@@ -0,0 +1,76 @@
+package znet
+
+import (
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+// 超出MsgID或者连接的限制之后，消息被限流，不同连接之间互不影响
+func TestRateLimiter_Limit(t *testing.T) {
+	limiter := NewRateLimiter(
+		utils.RateLimitConf{},
+		utils.RateLimitConf{Rate: 1, Burst: 3},
+		map[uint32]utils.RateLimitConf{3: {Rate: 1, Burst: 1}},
+		RateLimitActionDrop,
+	)
+	conn1 := &Connection{ConnID: 1}
+	conn2 := &Connection{ConnID: 2}
+
+	if _, limited := limiter.Limit(conn1, 3); limited {
+		t.Error("first MsgID 3 should not be limited")
+	}
+	if _, limited := limiter.Limit(conn1, 3); !limited {
+		t.Error("second MsgID 3 should be limited by msg rate limit")
+	}
+	if _, limited := limiter.Limit(conn2, 3); limited {
+		t.Error("MsgID 3 of another conn should not be limited")
+	}
+	for i := 0; i < 2; i++ {
+		if _, limited := limiter.Limit(conn1, 2); limited {
+			t.Error("MsgID 2 within conn burst should not be limited")
+		}
+	}
+	if _, limited := limiter.Limit(conn1, 2); !limited {
+		t.Error("MsgID 2 over conn burst should be limited")
+	}
+
+	// delay策略返回需要等待的时间
+	limiter = NewRateLimiter(utils.RateLimitConf{Rate: 10, Burst: 1}, utils.RateLimitConf{}, nil, RateLimitActionDelay)
+	limiter.Limit(conn1, 1)
+	if delay, limited := limiter.Limit(conn2, 1); !limited || delay <= 0 || delay > 100*time.Millisecond {
+		t.Errorf("delay = %v limited = %v, want 0 < delay <= 100ms", delay, limited)
+	}
+}
+
+// 按照disconnect策略，超出限制的客户端被断开，并且可以得到被限流的消息数
+func TestServer_RateLimitDisconnect(t *testing.T) {
+	s := NewServer(WithAddress("127.0.0.1", freePort(t))).(*Server)
+	s.SetRateLimiter(NewRateLimiter(utils.RateLimitConf{}, utils.RateLimitConf{Rate: 1, Burst: 2}, nil, RateLimitActionDisconnect))
+	s.AddRouter(1, &echoRouter{})
+
+	limited := make(chan uint64, 1)
+	s.SetOnConnStop(func(conn ziface.IConnection) {
+		limited <- conn.GetRateLimitedCount()
+	})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, s.Addr().String())
+	defer conn.Close()
+
+	binaryMsg, _ := NewDataPack().Pack(NewMessage(1, []byte("flood")))
+	for i := 0; i < 3; i++ {
+		conn.Write(binaryMsg)
+	}
+
+	select {
+	case n := <-limited:
+		if n != 1 {
+			t.Error("rate limited count =", n, "want 1")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("flooding client was not disconnected")
+	}
+}
@@ -1,10 +1,17 @@
 package znet
 
-import "zinx/ziface"
+import (
+	"errors"
+	"sync"
+	"zinx/ziface"
+)
 
 type Request struct {
-	conn ziface.IConnection // 已经和客户端建立好的连接
-	msg  ziface.IMessage    // 客户端请求的数据
+	conn           ziface.IConnection     // 已经和客户端建立好的连接
+	msg            ziface.IMessage        // 客户端请求的数据
+	onDone         func()                 // 请求处理完毕之后的回调，用于通知连接该请求已经不再处于处理中
+	properties     map[string]interface{} // 请求属性，第一次设置时创建
+	propertiesLock sync.RWMutex           // 保护请求属性的读写锁
 }
 
 func (r *Request) GetConnection() ziface.IConnection {
@@ -18,3 +25,37 @@ func (r *Request) GetData() []byte {
 func (r *Request) GetMsgID() uint32 {
 	return r.msg.GetMsgID()
 }
+
+// Reply 回复RPC请求，客户端的Call根据序列号得到这个回复
+// 连接需要使用SeqDataPack这样会将序列号封包的封包拆包模块
+func (r *Request) Reply(data []byte) error {
+	return r.conn.SendSeqMsg(r.msg.GetMsgID(), r.msg.GetSeq(), data)
+}
+
+func (r *Request) SetProperty(key string, value interface{}) {
+	r.propertiesLock.Lock()
+	defer r.propertiesLock.Unlock()
+
+	if r.properties == nil {
+		r.properties = make(map[string]interface{})
+	}
+	r.properties[key] = value
+}
+
+func (r *Request) GetProperty(key string) (interface{}, error) {
+	r.propertiesLock.RLock()
+	defer r.propertiesLock.RUnlock()
+
+	if value, ok := r.properties[key]; ok {
+		return value, nil
+	} else {
+		return nil, errors.New("property NOT FOUND")
+	}
+}
+
+// done 通知当前请求已经处理完毕
+func (r *Request) done() {
+	if r.onDone != nil {
+		r.onDone()
+	}
+}
@@ -1,116 +1,455 @@
 package znet
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 	"zinx/utils"
 	"zinx/ziface"
+	"zinx/zlog"
 )
 
 // Server IServer的接口实现，定义一个Server的服务器模块
 type Server struct {
-	Name        string                        // 服务器的名称
-	IPVersion   string                        // 服务器绑定的IP版本
-	IP          string                        // 服务器监听的IP
-	Port        int                           // 服务器监听的端口
-	MsgHandler  ziface.IMsgHandler            // 当前Server的消息管理模块，用来绑定MsgID和对应的处理业务API关系
-	ConnManager ziface.IConnManager           // 当前Server的连接管理模块
-	OnConnStart func(conn ziface.IConnection) // 当前Server创建连接之后自动调用的Hook函数
-	OnConnStop  func(conn ziface.IConnection) // 当前Server创建连接之后自动调用的Hook函数
+	Name            string                        // 服务器的名称
+	IPVersion       string                        // 服务器绑定的IP版本
+	Transport       string                        // 服务器监听使用的传输协议：tcp、kcp
+	IP              string                        // 服务器监听的IP
+	Port            int                           // 服务器监听的端口
+	WsPort          int                           // 服务器WebSocket监听的端口，为0则不开启WebSocket
+	WsPath          string                        // 服务器WebSocket监听的路径
+	WsUpgrader      *websocket.Upgrader           // 将HTTP请求升级为WebSocket连接的模块，可以通过CheckOrigin限制来源
+	TLSConfig       *tls.Config                   // TCP及WebSocket监听使用的TLS配置，为nil时根据配置文件中的证书创建，没有配置证书则不开启TLS
+	MsgHandler      ziface.IMsgHandler            // 当前Server的消息管理模块，用来绑定MsgID和对应的处理业务API关系
+	ConnManager     ziface.IConnManager           // 当前Server的连接管理模块
+	GroupManager    ziface.IGroupManager          // 当前Server的连接分组管理模块
+	DataPack        ziface.IDataPack              // 当前Server的封包拆包模块
+	RateLimiter     ziface.IRateLimiter           // 当前Server的限流模块，为nil则不限流
+	Metrics         *Metrics                      // 当前Server的统计模块
+	OnConnStart     func(conn ziface.IConnection) // 当前Server创建连接之后自动调用的Hook函数
+	OnConnStop      func(conn ziface.IConnection) // 当前Server创建连接之后自动调用的Hook函数
+	OnConnDead      func(conn ziface.IConnection) // 当前Server的连接空闲超时被认为失效时自动调用的Hook函数
+	listener        net.Listener                  // 当前Server的监听器
+	wsServer        *http.Server                  // 当前Server的WebSocket服务
+	metricsServer   *http.Server                  // 当前Server提供统计数据的HTTP服务
+	lock            sync.Mutex                    // 保护监听器的锁
+	connIDGen       uint32                        // 用来生成ConnID的计数器，原子操作
+	connWg          sync.WaitGroup                // 还没有停止完毕的连接，Stop等待所有连接调用完OnConnStop
+	exitChan        chan struct{}                 // 告知Server开始停止的channel
+	doneChan        chan struct{}                 // 告知Server已经完全停止的channel
+	stopOnce        sync.Once                     // 保证Server只会被停止一次
+	config          atomic.Value                  // 当前Server使用的配置（*utils.GlobalObj），重新加载配置时整体替换
+	overrides       []string                      // 通过Option修改过的配置项（Json字段名），重新加载配置时保持不变
+	overrideSetters []func(conf *utils.GlobalObj) // 通过Option对配置的修改，WithConfig替换配置之后重新应用
+	followReload    bool                          // 是否跟随utils.Reload更新配置，通过WithConfig指定配置时不跟随
+	unsubscribe     func()                        // 取消订阅配置变化
+	dispatcher      ziface.IDispatcher            // 通过Option指定的分发策略，为nil则按照配置选择
 }
 
 // NewServer 初始化Server模块
-func NewServer() ziface.IServer {
+// 默认使用utils.GetGlobalObject()的一份副本作为配置，并跟随utils.Reload更新可以热更新的配置项，opts可以为当前Server单独修改配置
+// 应用全部Option之后的配置需要通过校验，否则panic（与重复注册Router一样属于调用方的错误）
+func NewServer(opts ...Option) ziface.IServer {
+	conf := *utils.GetGlobalObject()
 	s := &Server{
-		Name:        utils.GlobalObject.Name,
-		IPVersion:   "tcp4",
-		IP:          utils.GlobalObject.IP,
-		Port:        8999,
-		MsgHandler:  NewMsgHandler(),
-		ConnManager: NewConnManager(),
+		IPVersion:    "tcp4",
+		WsUpgrader:   &websocket.Upgrader{},
+		ConnManager:  NewConnManager(),
+		GroupManager: NewGroupManager(),
+		Metrics:      NewMetrics(),
+		exitChan:     make(chan struct{}),
+		doneChan:     make(chan struct{}),
+		followReload: true,
 	}
+	s.config.Store(&conf)
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// 按照最终的配置初始化各个模块，已经通过Option指定的模块保持不变
+	c := s.GetConfig()
+	if err := c.Validate(); err != nil {
+		panic(err)
+	}
+	s.Name = c.Name
+	s.Transport = c.Transport
+	s.IP = c.IP
+	s.Port = c.Port
+	s.WsPort = c.WsPort
+	s.WsPath = c.WsPath
+	if s.MsgHandler == nil {
+		msgHandler := NewMsgHandlerWithPool(c.WorkerPoolSize, c.MaxWorkerPoolSize, c.PanicPolicy)
+		msgHandler.SetDispatcher(NewDispatcher(c.DispatchMode))
+		if c.AutoscaleMaxWorkers > 0 {
+			msgHandler.SetAutoscale(c.AutoscaleMinWorkers, c.AutoscaleMaxWorkers, time.Duration(c.AutoscaleInterval)*time.Millisecond)
+		}
+		s.MsgHandler = msgHandler
+	}
+	if s.dispatcher != nil {
+		s.MsgHandler.SetDispatcher(s.dispatcher)
+	}
+	// 业务处理的耗时记录在当前Server的统计模块中，只有注册了Router的MsgID单独统计
+	if msgHandler, ok := s.MsgHandler.(*MsgHandler); ok {
+		msgHandler.metrics = s.Metrics
+		s.Metrics.isRouted = msgHandler.hasRouter
+	}
+	if s.DataPack == nil {
+		s.DataPack = NewDataPack()
+	}
+	s.SetDataPack(s.DataPack)
+	s.RateLimiter = newRateLimiterFromConfig(c)
 	return s
 }
 
-func (s *Server) Start() {
-	fmt.Printf("[Zinx] Server Name: %s, Listener at IP: %s, Port: %d, is starting\n", s.Name, s.IP, s.Port)
-	fmt.Printf("[Zinx] Version: %s, MaxConn: %d, MaxPackageSize: %d\n",
-		utils.GlobalObject.Version,
-		utils.GlobalObject.MaxConn,
-		utils.GlobalObject.MaxPackageSize,
+// Start 启动服务器：加载TLS配置并监听服务器的地址，监听失败时返回错误，之后在新的goroutine中接收连接
+func (s *Server) Start() error {
+	conf := s.GetConfig()
+	zlog.Info("Zinx server is starting",
+		zlog.Any("server", s.Name),
+		zlog.Any("ip", s.IP),
+		zlog.Any("port", s.Port),
+		zlog.Any("transport", s.Transport),
+		zlog.Any("version", conf.Version),
+		zlog.Any("maxConn", conf.MaxConn),
+		zlog.Any("maxPackageSize", conf.MaxPackageSize),
 	)
 
-	go func() {
-		// 0、开启消息队列及Worker工作池
-		s.MsgHandler.StartWorkerPool()
-
-		// 1、获取一个TCP的Addr
-		addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
+	// 根据配置文件中的证书创建TLS配置
+	if s.TLSConfig == nil && conf.TLSCertFile != "" {
+		tlsConfig, err := NewTLSConfig(
+			conf.TLSCertFile,
+			conf.TLSKeyFile,
+			conf.TLSClientCAFile,
+			conf.TLSClientAuth,
+		)
 		if err != nil {
-			fmt.Println("Resolve TCP addr error:", err)
-			return
+			return fmt.Errorf("load TLS config error: %v", err)
 		}
+		s.TLSConfig = tlsConfig
+	}
 
-		// 2、监听服务器的地址
-		listener, err := net.ListenTCP(s.IPVersion, addr)
-		if err != nil {
-			fmt.Println("Listen", s.IPVersion, " error:", err)
-			return
-		}
-		fmt.Println("Start Zinx server", s.Name, "success")
-		var connID uint32
-		connID = 0
-
-		// 3、阻塞地等待客户端连接，处理客户端连接业务（读写）
-		for {
-			// 如果有客户端连接，阻塞会返回
-			conn, err := listener.AcceptTCP()
-			if err != nil {
-				fmt.Println("Accept error:", err)
-				continue
+	// 1、根据传输协议监听服务器的地址，WebSocket及统计数据的HTTP服务也在这里监听，任何一个失败都不启动服务器
+	listener, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("listen %s error: %v", s.Transport, err)
+	}
+	var wsListener, metricsListener net.Listener
+	if s.WsPort > 0 {
+		if wsListener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.IP, s.WsPort)); err != nil {
+			closeListener(listener)
+			return fmt.Errorf("listen websocket error: %v", err)
+		}
+	}
+	if conf.MetricsPort > 0 {
+		if metricsListener, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", conf.MetricsPort)); err != nil {
+			closeListener(listener)
+			if wsListener != nil {
+				wsListener.Close()
 			}
+			return fmt.Errorf("listen metrics error: %v", err)
+		}
+	}
 
-			// 设置最大连接个数的判断，如果超过最大连接，则关闭此新的连接
-			if s.ConnManager.Len() >= utils.GlobalObject.MaxConn {
-				// TODO 给客户端响应一个超过最大连接的错误包
-				fmt.Println("Too many connections, MaxConn =", utils.GlobalObject.MaxConn)
-				conn.Close()
-				continue
+	s.lock.Lock()
+	select {
+	case <-s.exitChan:
+		// Server在监听之前就已经停止了
+		s.lock.Unlock()
+		closeListener(listener)
+		if wsListener != nil {
+			wsListener.Close()
+		}
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
+		return errors.New("zinx server already stopped")
+	default:
+	}
+	s.listener = listener
+	if wsListener != nil {
+		s.wsServer = s.newWebSocketServer()
+	}
+	if metricsListener != nil {
+		s.metricsServer = s.newMetricsServer()
+	}
+	// 跟随重新加载的配置
+	if s.followReload {
+		s.unsubscribe = utils.Subscribe(s.applyConfigChange)
+	}
+	s.lock.Unlock()
+
+	// 2、开启消息队列及Worker工作池
+	s.MsgHandler.StartWorkerPool()
+
+	// 开启WebSocket服务，与TCP监听共用MsgHandler和ConnManager
+	if wsListener != nil {
+		go s.serveWebSocket(s.wsServer, wsListener)
+	}
+	// 在本地端口上提供统计数据
+	if metricsListener != nil {
+		go s.serveMetrics(s.metricsServer, metricsListener)
+	}
+
+	zlog.Info("Start Zinx server success", zlog.Any("server", s.Name), zlog.Any("addr", listener.Addr().String()))
+
+	// 3、阻塞地等待客户端连接，处理客户端连接业务（读写）
+	go s.accept(listener)
+	return nil
+}
+
+// accept 循环接收新的连接，直到监听器被关闭
+func (s *Server) accept(listener net.Listener) {
+	for {
+		// 如果有客户端连接，阻塞会返回
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.exitChan:
+				// Server已经停止，监听器被关闭，不再接收新的连接
+				zlog.Info("Zinx server stop accepting connections", zlog.Any("server", s.Name))
+				return
+			default:
 			}
-			// 将处理新连接的业务方法和conn进行绑定，得到连接模块
-			dealConn := NewConnection(s, conn, connID, s.MsgHandler)
-			connID++
+			zlog.Warn("Accept error", zlog.Err(err))
+			continue
+		}
+
+		// TLS连接需要先完成握手，为了不阻塞监听，在新的goroutine中处理
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			go s.handleTLSConn(tlsConn)
+			continue
+		}
+		s.handleConn(conn)
+	}
+}
 
-			go dealConn.Start()
+// closeListener 关闭启动失败时已经创建的监听器，KCP的监听器需要同时关闭UDP socket
+func closeListener(listener net.Listener) {
+	if l, ok := listener.(*kcpListener); ok {
+		l.closeSocket()
+		return
+	}
+	listener.Close()
+}
+
+// Addr 获取服务器监听的地址，监听端口为0时可以得到系统分配的端口，没有启动时返回nil
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// listen 根据传输协议监听服务器的地址
+func (s *Server) listen() (net.Listener, error) {
+	address := fmt.Sprintf("%s:%d", s.IP, s.Port)
+
+	switch s.Transport {
+	case TransportKCP:
+		return listenKCP(address)
+	case TransportTCP, "":
+		// 获取一个TCP的Addr
+		addr, err := net.ResolveTCPAddr(s.IPVersion, address)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.ListenTCP(s.IPVersion, addr)
+		if err != nil {
+			return nil, err
+		}
+		// 开启了TLS，TCP连接都需要经过TLS加密
+		if s.TLSConfig != nil {
+			return tls.NewListener(listener, s.TLSConfig), nil
 		}
-	}()
+		return listener, nil
+	default:
+		return nil, errors.New("unsupported transport: " + s.Transport)
+	}
 }
 
+// handleConn 处理一个新建立的客户端连接（TCP、WebSocket等）
+func (s *Server) handleConn(conn net.Conn) {
+	// 设置最大连接个数的判断，如果超过最大连接，则关闭此新的连接
+	if maxConn := s.GetConfig().MaxConn; s.ConnManager.Len() >= maxConn {
+		zlog.Warn("Too many connections", zlog.Any("maxConn", maxConn), zlog.RemoteAddr(conn.RemoteAddr()))
+		s.Metrics.ConnRejected(RejectReasonMaxConn)
+		rejectConn(s.GetDataPack(), conn, ErrCodeOverCapacity)
+		return
+	}
+	// 消息队列已经饱和时拒绝新的连接，避免进一步加重负载
+	if threshold := s.GetConfig().AdmissionThreshold; !admit(s.MsgHandler, threshold) {
+		zlog.Warn("Task queues are saturated, reject connection", zlog.Any("threshold", threshold), zlog.RemoteAddr(conn.RemoteAddr()))
+		s.Metrics.ConnRejected(RejectReasonOverload)
+		rejectConn(s.GetDataPack(), conn, ErrCodeServerBusy)
+		return
+	}
+
+	// TLS握手、WebSocket升级完成时Server可能已经开始停止，此时直接关闭连接
+	// 与Stop使用同一把锁，Stop开始等待之后不会再有新的连接被注册
+	s.lock.Lock()
+	select {
+	case <-s.exitChan:
+		s.lock.Unlock()
+		zlog.Debug("Server is stopping, close new connection", zlog.RemoteAddr(conn.RemoteAddr()))
+		s.Metrics.ConnRejected(RejectReasonStopping)
+		conn.Close()
+		return
+	default:
+	}
+	s.Metrics.ConnAccepted()
+
+	// 将处理新连接的业务方法和conn进行绑定，得到连接模块
+	connID := atomic.AddUint32(&s.connIDGen, 1) - 1
+	dealConn := NewConnection(s, conn, connID, s.MsgHandler)
+	s.lock.Unlock()
+
+	go dealConn.Start()
+}
+
+// Stop 优雅地停止服务器：不再接收新的连接，等待每个连接处理完已分发的请求，
+// 对每个连接调用一次OnConnStop，最后停止Worker工作池，让Serve返回
+// 每个连接等待已分发请求的时间最多为DrainTimeout，Stop等待所有连接都停止完毕之后才返回
 func (s *Server) Stop() {
-	// TODO 将一些服务器的资源、状态或者已经开辟的连接信息，进行停止或回收
-	s.ConnManager.Clear()
-	fmt.Println("Stop Zinx server", s.Name, "success")
+	s.stopOnce.Do(func() {
+		zlog.Info("Zinx server is stopping", zlog.Any("server", s.Name))
+
+		// 1、关闭监听器，停止接收新的连接
+		s.lock.Lock()
+		close(s.exitChan)
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		if s.wsServer != nil {
+			s.wsServer.Close()
+		}
+		if s.metricsServer != nil {
+			s.metricsServer.Close()
+		}
+		if s.unsubscribe != nil {
+			s.unsubscribe()
+		}
+		s.lock.Unlock()
+
+		// 2、停止所有连接，每个连接会在处理完已分发的请求之后调用OnConnStop
+		s.ConnManager.Clear()
+
+		// 3、等待所有连接停止完毕，此时每个连接都已经调用了OnConnStop
+		s.connWg.Wait()
+
+		// 4、停止Worker工作池
+		s.MsgHandler.StopWorkerPool()
+
+		// 5、KCP的会话与监听器共用同一个UDP socket，在所有连接停止之后再关闭
+		s.lock.Lock()
+		if listener, ok := s.listener.(*kcpListener); ok {
+			listener.closeSocket()
+		}
+		s.lock.Unlock()
+
+		close(s.doneChan)
+		zlog.Info("Stop Zinx server success", zlog.Any("server", s.Name))
+	})
 }
 
-func (s *Server) Serve() {
+// Serve 启动服务器并阻塞，直到服务器停止，启动失败时返回错误
+func (s *Server) Serve() error {
 	// 启动server的服务功能
-	s.Start()
+	if err := s.Start(); err != nil {
+		return err
+	}
 
-	// TODO 做一些启动服务器之外的额外业务
+	// 阻塞等待服务器停止，收到中断信号时优雅地停止服务器，收到SIGHUP时重新加载配置
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signalChan)
 
-	// 阻塞状态
-	select {}
+	for {
+		select {
+		case sig := <-signalChan:
+			zlog.Info("Zinx server receive signal", zlog.Any("server", s.Name), zlog.Any("signal", sig.String()))
+			if sig == syscall.SIGHUP {
+				if _, err := utils.Reload(); err != nil {
+					zlog.Error("Reload zinx config error", zlog.Err(err))
+				}
+				continue
+			}
+			s.Stop()
+		case <-s.doneChan:
+		}
+		return nil
+	}
 }
 
 func (s *Server) AddRouter(msgId uint32, router ziface.IRouter) {
 	s.MsgHandler.AddRouter(msgId, router)
-	fmt.Println("Add router success")
+	zlog.Debug("Add router success", zlog.MsgID(msgId))
+}
+
+func (s *Server) Use(middlewares ...ziface.Middleware) {
+	s.MsgHandler.Use(middlewares...)
+}
+
+func (s *Server) UseRouter(msgId uint32, middlewares ...ziface.Middleware) {
+	s.MsgHandler.UseRouter(msgId, middlewares...)
+}
+
+func (s *Server) GetConfig() *utils.GlobalObj {
+	return s.config.Load().(*utils.GlobalObj)
+}
+
+// applyConfigChange 将重新加载之后生效的配置项应用到当前Server，通过Option修改过的配置项保持不变
+func (s *Server) applyConfigChange(change *utils.ConfigChange) {
+	conf := change.ApplyTo(s.GetConfig(), s.overrides...)
+	s.config.Store(conf)
+	if setter, ok := s.DataPack.(maxPackageSizeSetter); ok {
+		setter.SetMaxPackageSize(conf.MaxPackageSize)
+	}
 }
 
 func (s *Server) GetConnManager() ziface.IConnManager {
 	return s.ConnManager
 }
 
+func (s *Server) GetGroupManager() ziface.IGroupManager {
+	return s.GroupManager
+}
+
+func (s *Server) GetMsgHandler() ziface.IMsgHandler {
+	return s.MsgHandler
+}
+
+func (s *Server) SetDataPack(dataPack ziface.IDataPack) {
+	// 封包拆包模块按照当前Server的配置限制最大包长度
+	if setter, ok := dataPack.(maxPackageSizeSetter); ok {
+		setter.SetMaxPackageSize(s.GetConfig().MaxPackageSize)
+	}
+	s.DataPack = dataPack
+}
+
+func (s *Server) GetDataPack() ziface.IDataPack {
+	return s.DataPack
+}
+
+func (s *Server) SetRateLimiter(rateLimiter ziface.IRateLimiter) {
+	s.RateLimiter = rateLimiter
+}
+
+func (s *Server) GetRateLimiter() ziface.IRateLimiter {
+	return s.RateLimiter
+}
+
 func (s *Server) SetOnConnStart(hookFunc func(conn ziface.IConnection)) {
 	s.OnConnStart = hookFunc
 }
@@ -121,14 +460,28 @@ func (s *Server) SetOnConnStop(hookFunc func(conn ziface.IConnection)) {
 
 func (s *Server) CallOnConnStart(conn ziface.IConnection) {
 	if s.OnConnStart != nil {
-		fmt.Println("Call OnConnStart()...")
+		zlog.Debug("Call OnConnStart", zlog.ConnID(conn.GetConnID()))
 		s.OnConnStart(conn)
 	}
 }
 
 func (s *Server) CallOnConnStop(conn ziface.IConnection) {
 	if s.OnConnStop != nil {
-		fmt.Println("Call OnConnStop()...")
+		zlog.Debug("Call OnConnStop", zlog.ConnID(conn.GetConnID()))
 		s.OnConnStop(conn)
 	}
 }
+
+func (s *Server) SetOnConnDead(hookFunc func(conn ziface.IConnection)) {
+	s.OnConnDead = hookFunc
+}
+
+func (s *Server) CallOnConnDead(conn ziface.IConnection) {
+	if s.OnConnDead != nil {
+		zlog.Debug("Call OnConnDead", zlog.ConnID(conn.GetConnID()))
+		s.OnConnDead(conn)
+		return
+	}
+	// 默认直接停止失效的连接
+	conn.Stop()
+}
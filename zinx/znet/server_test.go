@@ -0,0 +1,358 @@
+package znet
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/utils"
+	"zinx/ziface"
+)
+
+// slowRouter 模拟一个处理较慢的业务
+type slowRouter struct {
+	BaseRouter
+	handled int32
+}
+
+func (r *slowRouter) Handle(request ziface.IRequest) {
+	time.Sleep(200 * time.Millisecond)
+	atomic.AddInt32(&r.handled, 1)
+}
+
+// 优雅关闭时，已经分发的请求需要处理完毕，OnConnStop只调用一次，Serve返回
+func TestServer_Stop(t *testing.T) {
+	port := freePort(t)
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	s := NewServer(WithAddress("127.0.0.1", port)).(*Server)
+
+	router := &slowRouter{}
+	s.AddRouter(1, router)
+
+	var stopCount int32
+	s.SetOnConnStop(func(conn ziface.IConnection) {
+		// OnConnStop被调用时，已分发的请求应该已经处理完毕
+		if atomic.LoadInt32(&router.handled) != 1 {
+			t.Error("OnConnStop called before in-flight request finished")
+		}
+		atomic.AddInt32(&stopCount, 1)
+	})
+
+	serveDone := make(chan struct{})
+	go func() {
+		s.Serve()
+		close(serveDone)
+	}()
+
+	conn := dialRetry(t, addr)
+	defer conn.Close()
+
+	binaryMsg, _ := NewDataPack().Pack(NewMessage(1, []byte("ping")))
+	if _, err := conn.Write(binaryMsg); err != nil {
+		t.Fatal("Client write error:", err)
+	}
+	// 等待请求被分发
+	time.Sleep(50 * time.Millisecond)
+
+	s.Stop()
+
+	select {
+	case <-serveDone:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Stop")
+	}
+	if n := atomic.LoadInt32(&stopCount); n != 1 {
+		t.Error("OnConnStop called", n, "times, want 1")
+	}
+	if n := s.GetConnManager().Len(); n != 0 {
+		t.Error("remaining conn num =", n, "want 0")
+	}
+
+	// 停止之后不再接收新的连接
+	if c, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+		c.Close()
+		t.Error("Server still accepting connections after Stop")
+	}
+}
+
+// 业务一直没有处理完时，连接最多等待DrainTimeout，Stop在OnConnStop调用完之后才返回
+func TestServer_StopDrainTimeout(t *testing.T) {
+	conf := utils.NewDefaultGlobalObj()
+	conf.DrainTimeout = 100
+	var stopped int32
+	s := NewServer(
+		WithConfig(conf),
+		WithAddress("127.0.0.1", freePort(t)),
+		WithOnConnStop(func(conn ziface.IConnection) {
+			atomic.AddInt32(&stopped, 1)
+		}),
+	).(*Server)
+	router := &blockRouter{started: make(chan struct{}, 1), unblock: make(chan struct{})}
+	defer close(router.unblock)
+	s.AddRouter(1, router)
+	s.Start()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal("Client dial error:", err)
+	}
+	defer conn.Close()
+	binaryMsg, _ := NewDataPack().Pack(NewMessage(1, []byte("ping")))
+	conn.Write(binaryMsg)
+	<-router.started
+
+	start := time.Now()
+	s.Stop()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed >= 200*time.Millisecond {
+		t.Errorf("Stop took %v, want about drain timeout 100ms", elapsed)
+	}
+	if n := atomic.LoadInt32(&stopped); n != 1 {
+		t.Errorf("OnConnStop called %d times before Stop returned, want 1", n)
+	}
+}
+
+// 监听失败或者TLS配置错误时Start和Serve返回错误，不会一直阻塞
+func TestServer_StartError(t *testing.T) {
+	used, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer used.Close()
+
+	s := NewServer(WithAddress("127.0.0.1", used.Addr().(*net.TCPAddr).Port))
+	if err := s.Start(); err == nil {
+		t.Error("Start on a used port succeeded")
+	}
+
+	conf := utils.NewDefaultGlobalObj()
+	conf.TLSCertFile, conf.TLSKeyFile = "testdata/missing.crt", "testdata/missing.key"
+	s = NewServer(WithConfig(conf), WithAddress("127.0.0.1", freePort(t)))
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve()
+	}()
+	select {
+	case err := <-serveErr:
+		if err == nil {
+			t.Error("Serve with missing TLS cert returned nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve blocked after TLS config error")
+	}
+}
+
+// 客户端发送心跳会收到回复，空闲超时之后连接会被判定为失效
+func TestServer_IdleTimeout(t *testing.T) {
+	conf := utils.NewDefaultGlobalObj()
+	conf.HeartbeatInterval, conf.IdleTimeout = 20, 200
+	s := NewServer(WithConfig(conf), WithAddress("127.0.0.1", freePort(t))).(*Server)
+	dead := make(chan uint32, 1)
+	s.SetOnConnDead(func(conn ziface.IConnection) {
+		dead <- conn.GetConnID()
+		conn.Stop()
+	})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, s.Addr().String())
+	defer conn.Close()
+
+	// 发送心跳，应该收到同一个ID的回复
+	dp := NewDataPack()
+	ping, _ := dp.Pack(NewMessage(HeartbeatMsgID, nil))
+	if _, err := conn.Write(ping); err != nil {
+		t.Fatal("Client write error:", err)
+	}
+	headData := make([]byte, dp.GetHeadLen())
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, headData); err != nil {
+		t.Fatal("Client read pong error:", err)
+	}
+	if msg, err := dp.Unpack(headData); err != nil || msg.GetMsgID() != HeartbeatMsgID {
+		t.Fatal("unexpected pong:", msg, err)
+	}
+
+	// 不再发送任何数据，等待空闲超时
+	select {
+	case <-dead:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not detected")
+	}
+}
+
+// echoRouter 将请求的数据原样回复给客户端
+type echoRouter struct {
+	BaseRouter
+}
+
+func (r *echoRouter) Handle(request ziface.IRequest) {
+	request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+// WebSocket连接与TCP连接共用Router、MsgHandler以及ConnManager
+func TestServer_WebSocket(t *testing.T) {
+	wsPort := freePort(t)
+	s := NewServer(WithAddress("127.0.0.1", freePort(t))).(*Server)
+	s.WsPort = wsPort
+	s.WsPath = "/ws"
+	s.AddRouter(1, &echoRouter{})
+
+	started := make(chan ziface.IConnection, 1)
+	s.SetOnConnStart(func(conn ziface.IConnection) {
+		started <- conn
+	})
+	s.Start()
+	defer s.Stop()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:"+strconv.Itoa(wsPort)+"/ws", nil)
+	if err != nil {
+		t.Fatal("Client dial error:", err)
+	}
+	defer ws.Close()
+
+	conn := <-started
+	if conn.GetTCPConnection() != nil || conn.GetConn() == nil {
+		t.Error("WebSocket connection should not expose a TCP socket")
+	}
+
+	// 一个二进制帧中可以包含多个消息
+	dp := NewDataPack()
+	data1, _ := dp.Pack(NewMessage(1, []byte("hello")))
+	data2, _ := dp.Pack(NewMessage(1, []byte("zinx")))
+	if err := ws.WriteMessage(websocket.BinaryMessage, append(data1, data2...)); err != nil {
+		t.Fatal("Client write error:", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range []string{"hello", "zinx"} {
+		_, reply, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal("Client read error:", err)
+		}
+		msg, err := dp.ReadMsg(bytes.NewReader(reply))
+		if err != nil {
+			t.Fatal("Client unpack error:", err)
+		}
+		if msg.GetMsgID() != 1 || string(msg.GetData()) != want {
+			t.Errorf("reply ID = %d Data = %s, want ID = 1 Data = %s", msg.GetMsgID(), msg.GetData(), want)
+		}
+	}
+}
+
+// 通过本地回环地址以KCP协议收发消息
+func TestServer_KCP(t *testing.T) {
+	s := NewServer(WithAddress("127.0.0.1", freePort(t)), WithTransport(TransportKCP)).(*Server)
+	s.AddRouter(1, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+
+	conn, err := DialKCP(s.Addr().String())
+	if err != nil {
+		t.Fatal("Client dial error:", err)
+	}
+	defer conn.Close()
+
+	dp := NewDataPack()
+	for _, data := range []string{"hello", "kcp"} {
+		binaryMsg, _ := dp.Pack(NewMessage(1, []byte(data)))
+		if _, err := conn.Write(binaryMsg); err != nil {
+			t.Fatal("Client write error:", err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for _, want := range []string{"hello", "kcp"} {
+		msg, err := dp.ReadMsg(conn)
+		if err != nil {
+			t.Fatal("Client read error:", err)
+		}
+		if msg.GetMsgID() != 1 || string(msg.GetData()) != want {
+			t.Errorf("reply ID = %d Data = %s, want ID = 1 Data = %s", msg.GetMsgID(), msg.GetData(), want)
+		}
+	}
+	if n := s.GetConnManager().Len(); n != 1 {
+		t.Error("conn num =", n, "want 1")
+	}
+}
+
+// 同一个进程中的多个Server使用各自的配置，重新加载配置时通过Option修改过的配置项保持不变
+func TestNewServer_Options(t *testing.T) {
+	gamePort, adminPort := freePort(t), freePort(t)
+	game := NewServer(WithAddress("127.0.0.1", gamePort), WithMaxConn(1)).(*Server)
+	admin := NewServer(WithName("admin"), WithAddress("127.0.0.1", adminPort), WithCodec(NewSeqDataPack()), WithWorkerPool(0, 0)).(*Server)
+	if game.Port != gamePort || admin.Port != adminPort || admin.Name != "admin" {
+		t.Fatalf("game port = %d, admin port = %d name = %s", game.Port, admin.Port, admin.Name)
+	}
+	if game.GetConfig().MaxConn != 1 || admin.GetConfig().MaxConn != utils.GetGlobalObject().MaxConn {
+		t.Errorf("game max_conn = %d, admin max_conn = %d", game.GetConfig().MaxConn, admin.GetConfig().MaxConn)
+	}
+	if admin.GetConfig().WorkerPoolSize != 0 || utils.GetGlobalObject().WorkerPoolSize == 0 {
+		t.Error("WithWorkerPool should only change the config of its own server")
+	}
+
+	// 只有一个连接的名额，第二个连接被拒绝
+	connected := make(chan uint32, 2)
+	game.SetOnConnStart(func(conn ziface.IConnection) {
+		connected <- conn.GetConnID()
+	})
+	game.Start()
+	defer game.Stop()
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn := dialRetry(t, game.Addr().String())
+		defer conn.Close()
+		conns = append(conns, conn)
+		if i == 0 {
+			<-connected
+		}
+	}
+	expectRejected(t, conns[1], game.GetDataPack(), ErrOverCapacity)
+
+	// 重新加载配置之后，max_conn保持Option的值，max_package_size同步到封包拆包模块
+	newConf := *game.GetConfig()
+	newConf.MaxConn, newConf.MaxPackageSize = 100, 128
+	game.applyConfigChange(&utils.ConfigChange{
+		Old:     utils.GetGlobalObject(),
+		New:     &newConf,
+		Applied: []string{"max_conn", "max_package_size"},
+	})
+	if conf := game.GetConfig(); conf.MaxConn != 1 || conf.MaxPackageSize != 128 {
+		t.Errorf("after reload max_conn = %d max_package_size = %d, want 1, 128", conf.MaxConn, conf.MaxPackageSize)
+	}
+	if max := game.DataPack.(*DataPack).GetMaxPackageSize(); max != 128 {
+		t.Error("datapack max package size =", max, "want 128")
+	}
+}
+
+// WithConfig与其他Option的顺序无关，不合法的配置在NewServer中panic
+func TestNewServer_ConfigOrder(t *testing.T) {
+	conf := utils.NewDefaultGlobalObj()
+	conf.MaxConn = 5
+	s := NewServer(WithName("before"), WithAddress("127.0.0.1", 9000), WithConfig(conf)).(*Server)
+	if c := s.GetConfig(); c.Name != "before" || c.Port != 9000 || c.MaxConn != 5 {
+		t.Errorf("name = %s, port = %d, max_conn = %d, want before, 9000, 5", c.Name, c.Port, c.MaxConn)
+	}
+	if conf.Name == "before" {
+		t.Error("WithConfig should not modify the given config")
+	}
+
+	for name, opt := range map[string]Option{
+		"worker pool":  WithWorkerPool(4, 0),
+		"autoscale":    WithAutoscale(8, 2),
+		"negative max": WithMaxConn(-1),
+		"zero port":    WithAddress("127.0.0.1", 0),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: invalid config accepted", name)
+				}
+			}()
+			NewServer(opt)
+		}()
+	}
+}
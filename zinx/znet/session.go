@@ -0,0 +1,271 @@
+package znet
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"zinx/ziface"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyExchangeMsgID 框架保留的密钥交换消息ID，开启会话加密时客户端和服务器交换的第一个消息，内容为32字节的X25519公钥
+// 双方使用X25519得到共享密钥，再通过HKDF-SHA256为每个方向派生一个ChaCha20-Poly1305密钥
+// 此后每次写入连接的数据（一个完整的封包）被加密为一条记录：DataLen uint32（小端字节序）+ 密文（包含16字节的认证标签）
+// 每个方向的nonce是从0开始递增的计数器，不在记录中传输，因此被重放、重新排序或者篡改的记录都无法通过认证
+// 注意：密钥交换没有校验服务器的身份，只能防御窃听和篡改，不能防御主动的中间人攻击，需要校验身份时应使用TLS
+const KeyExchangeMsgID uint32 = 0xFFFC
+
+// HandshakeTimeout 开启会话加密时等待对方密钥交换消息的最长时间
+const HandshakeTimeout = 10 * time.Second
+
+// sessionTagSize ChaCha20-Poly1305认证标签的长度
+const sessionTagSize = 16
+
+// sessionInfo 派生会话密钥时使用的上下文信息
+const sessionInfo = "zinx session v1"
+
+// sessionKey 一端生成的临时X25519密钥对
+type sessionKey struct {
+	private []byte
+	public  []byte
+}
+
+// newSessionKey 生成一个临时的X25519密钥对，每个连接使用新的密钥对
+func newSessionKey() (*sessionKey, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionKey{private: private, public: public}, nil
+}
+
+// deriveCiphers 根据自己的私钥和对方的公钥派生发送和接收使用的AEAD，isServer决定使用哪个方向的密钥发送
+func (k *sessionKey) deriveCiphers(peerPublic []byte, isServer bool) (send, recv cipher.AEAD, err error) {
+	if len(peerPublic) != curve25519.PointSize {
+		return nil, nil, errors.New("invalid key exchange public key")
+	}
+	// 对方的公钥为低阶点时共享密钥全为0，X25519返回错误
+	shared, err := curve25519.X25519(k.private, peerPublic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 盐为客户端公钥 + 服务器公钥，双方得到相同的密钥
+	clientPublic, serverPublic := k.public, peerPublic
+	if isServer {
+		clientPublic, serverPublic = peerPublic, k.public
+	}
+	salt := append(append([]byte{}, clientPublic...), serverPublic...)
+
+	// 前32字节为客户端发送给服务器的密钥，后32字节为服务器发送给客户端的密钥
+	keys := make([]byte, 2*chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(sessionInfo)), keys); err != nil {
+		return nil, nil, err
+	}
+	clientToServer, err := chacha20poly1305.New(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+	serverToClient, err := chacha20poly1305.New(keys[chacha20poly1305.KeySize:])
+	if err != nil {
+		return nil, nil, err
+	}
+	if isServer {
+		return serverToClient, clientToServer, nil
+	}
+	return clientToServer, serverToClient, nil
+}
+
+// secureConn 完成密钥交换之后的加密连接，每次Write加密为一条记录，Read解密记录之后返回明文
+// 其余的方法（RemoteAddr、SetDeadline、Close等）直接使用原始的连接
+type secureConn struct {
+	net.Conn
+	dp        ziface.IDataPack // 封包拆包模块，用于限制记录的最大长度
+	send      cipher.AEAD      // 加密发送数据的AEAD
+	recv      cipher.AEAD      // 解密接收数据的AEAD
+	sendSeq   uint64           // 下一条发送记录的序号，作为nonce
+	recvSeq   uint64           // 下一条接收记录的序号，作为nonce
+	writeLock sync.Mutex       // 保证记录按照序号的顺序写入连接
+	plain     []byte           // 已经解密但还没有被读取的数据
+}
+
+func newSecureConn(conn net.Conn, dp ziface.IDataPack, send, recv cipher.AEAD) *secureConn {
+	return &secureConn{
+		Conn: conn,
+		dp:   dp,
+		send: send,
+		recv: recv,
+	}
+}
+
+// nonce 将记录的序号转换为nonce
+func (s *secureConn) nonce(seq uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce, seq)
+	return nonce
+}
+
+// Write 将一次写入的数据加密为一条记录写入连接
+func (s *secureConn) Write(data []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	record := make([]byte, 4, 4+len(data)+sessionTagSize)
+	record = s.send.Seal(record, s.nonce(s.sendSeq), data, nil)
+	binary.LittleEndian.PutUint32(record, uint32(len(record)-4))
+	s.sendSeq++
+
+	if _, err := s.Conn.Write(record); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Read 读取解密之后的数据，当前记录的数据读完之后再读取并解密下一条记录
+func (s *secureConn) Read(b []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if err := s.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// readRecord 读取一条记录并解密，认证失败（被篡改、重放或者使用了错误的密钥）时返回错误
+func (s *secureConn) readRecord() error {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(s.Conn, head); err != nil {
+		return err
+	}
+	recordLen := binary.LittleEndian.Uint32(head)
+	if recordLen < sessionTagSize {
+		return errors.New("invalid encrypted record")
+	}
+	// 一条记录最多包含一个完整的封包
+	if max := maxPackageSize(s.dp); max > 0 && recordLen > max+s.dp.GetHeadLen()+sessionTagSize {
+		return errTooLargePackage
+	}
+
+	record := make([]byte, recordLen)
+	if _, err := io.ReadFull(s.Conn, record); err != nil {
+		return err
+	}
+	plain, err := s.recv.Open(record[:0], s.nonce(s.recvSeq), record, nil)
+	if err != nil {
+		return errors.New("encrypted record authentication failed")
+	}
+	s.recvSeq++
+	s.plain = plain
+	return nil
+}
+
+// readKeyExchange 从连接中读取对方的密钥交换消息，得到对方的公钥
+func readKeyExchange(dp ziface.IDataPack, conn net.Conn) ([]byte, error) {
+	msg, err := dp.ReadMsg(conn)
+	if err != nil {
+		return nil, err
+	}
+	// 服务器在密钥交换之前拒绝了连接
+	if msg.GetMsgID() == ErrorMsgID {
+		return nil, newServerError(msg.GetData())
+	}
+	if msg.GetMsgID() != KeyExchangeMsgID {
+		return nil, errors.New("key exchange required")
+	}
+	if msg.GetDataLen() != curve25519.PointSize {
+		return nil, errors.New("invalid key exchange public key")
+	}
+	return msg.GetData(), nil
+}
+
+// writeKeyExchange 将自己的公钥作为密钥交换消息直接写入连接
+func writeKeyExchange(dp ziface.IDataPack, conn net.Conn, public []byte) error {
+	binaryMsg, err := dp.Pack(NewMessage(KeyExchangeMsgID, public))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(binaryMsg)
+	return err
+}
+
+// serverHandshake 服务器一端的密钥交换：等待客户端的公钥，回复自己的公钥，得到加密的连接
+func serverHandshake(dp ziface.IDataPack, conn net.Conn) (net.Conn, error) {
+	peerPublic, err := readKeyExchange(dp, conn)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newSessionKey()
+	if err != nil {
+		return nil, err
+	}
+	send, recv, err := key.deriveCiphers(peerPublic, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyExchange(dp, conn, key.public); err != nil {
+		return nil, err
+	}
+	return newSecureConn(conn, dp, send, recv), nil
+}
+
+// clientHandshake 客户端一端的密钥交换：发送自己的公钥，等待服务器的公钥，得到加密的连接
+func clientHandshake(dp ziface.IDataPack, conn net.Conn) (net.Conn, error) {
+	key, err := newSessionKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyExchange(dp, conn, key.public); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	peerPublic, err := readKeyExchange(dp, conn)
+	if err != nil {
+		return nil, err
+	}
+	send, recv, err := key.deriveCiphers(peerPublic, false)
+	if err != nil {
+		return nil, err
+	}
+	return newSecureConn(conn, dp, send, recv), nil
+}
+
+// startSession 开启会话加密时，在启动Reader和Writer之前完成密钥交换，此后通过加密的连接读写数据
+func (c *Connection) startSession() error {
+	c.Conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	// 握手的过程中连接已经开始停止，Stop设置的读超时可能已经被覆盖，直接返回
+	select {
+	case <-c.stopChan:
+		return errors.New("connection stopped during key exchange")
+	default:
+	}
+
+	stream, err := serverHandshake(c.Server.GetDataPack(), c.Conn)
+	if err != nil {
+		return err
+	}
+	c.Conn.SetReadDeadline(time.Time{})
+	select {
+	case <-c.stopChan:
+		return errors.New("connection stopped during key exchange")
+	default:
+	}
+	c.stream = stream
+	return nil
+}
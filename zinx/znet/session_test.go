@@ -0,0 +1,130 @@
+package znet
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// bufConn 读写同一个缓冲的连接，用于检查加密之后的记录
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (b *bufConn) Read(p []byte) (int, error) {
+	return b.buf.Read(p)
+}
+
+func (b *bufConn) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// 加密的记录可以被对方解密，被重放或者篡改的记录被拒绝
+func TestSecureConn_ReplayAndTamper(t *testing.T) {
+	clientKey, _ := newSessionKey()
+	serverKey, _ := newSessionKey()
+	clientSend, clientRecv, err := clientKey.deriveCiphers(serverKey.public, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSend, serverRecv, err := serverKey.deriveCiphers(clientKey.public, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dp := NewDataPack()
+	newPair := func() (*secureConn, *secureConn, *bufConn) {
+		wire := &bufConn{}
+		return newSecureConn(wire, dp, clientSend, clientRecv), newSecureConn(wire, dp, serverSend, serverRecv), wire
+	}
+	frame, _ := dp.Pack(NewMessage(1, []byte("move to 100,200")))
+
+	// 正常的记录
+	client, server, _ := newPair()
+	client.Write(frame)
+	msg, err := dp.ReadMsg(server)
+	if err != nil || string(msg.GetData()) != "move to 100,200" {
+		t.Fatalf("read msg = %v, %v", msg, err)
+	}
+
+	// 重放同一条记录
+	client, server, wire := newPair()
+	client.Write(frame)
+	record := append([]byte{}, wire.buf.Bytes()...)
+	wire.buf.Write(record)
+	if _, err := dp.ReadMsg(server); err != nil {
+		t.Fatal("first record rejected:", err)
+	}
+	if _, err := dp.ReadMsg(server); err == nil {
+		t.Error("replayed record was accepted")
+	}
+
+	// 篡改记录中的一个字节
+	client, server, wire = newPair()
+	client.Write(frame)
+	wire.buf.Bytes()[6] ^= 0x01
+	if _, err := dp.ReadMsg(server); err == nil {
+		t.Error("tampered record was accepted")
+	}
+}
+
+// 开启会话加密的服务器与加密的客户端正常通信，拒绝没有进行密钥交换的客户端
+func TestClient_Encryption(t *testing.T) {
+	var started int32
+	port := freePort(t)
+	s := NewServer(
+		WithAddress("127.0.0.1", port),
+		WithEncryption(true),
+		WithOnConnStart(func(conn ziface.IConnection) {
+			atomic.AddInt32(&started, 1)
+		}),
+	).(*Server)
+	s.AddRouter(1, &echoRouter{})
+	s.Start()
+	defer s.Stop()
+
+	c := NewClient("127.0.0.1", port).(*Client)
+	c.Encryption = true
+	router := &replyRouter{replies: make(chan string, 1)}
+	c.AddRouter(1, router)
+	connected := make(chan struct{}, 1)
+	c.SetOnConnect(func(conn ziface.IConnection) {
+		connected <- struct{}{}
+	})
+	c.Start()
+	defer c.Stop()
+
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not connect")
+	}
+	if err := c.SendMsg(1, []byte("secret")); err != nil {
+		t.Fatal("Client send error:", err)
+	}
+	select {
+	case reply := <-router.replies:
+		if reply != "secret" {
+			t.Errorf("reply = %s, want secret", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive reply")
+	}
+
+	// 没有进行密钥交换的客户端直接被断开
+	conn := dialRetry(t, s.Addr().String())
+	defer conn.Close()
+	frame, _ := NewDataPack().Pack(NewMessage(1, []byte("plain")))
+	conn.Write(frame)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("plain client was not disconnected")
+	}
+	if n := atomic.LoadInt32(&started); n != 1 {
+		t.Errorf("OnConnStart called %d times, want 1", n)
+	}
+}
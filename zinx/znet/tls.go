@@ -0,0 +1,95 @@
+package znet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"time"
+	"zinx/zlog"
+)
+
+// 客户端证书的校验策略
+const (
+	TLSClientAuthNone          = "none"            // 不要求客户端提供证书
+	TLSClientAuthVerifyIfGiven = "verify_if_given" // 客户端可以不提供证书，提供了则必须通过CA校验（例如只有内部工具提供证书）
+	TLSClientAuthRequire       = "require"         // 客户端必须提供通过CA校验的证书
+)
+
+// tlsHandshakeTimeout TLS握手的超时时间
+const tlsHandshakeTimeout = 10 * time.Second
+
+// NewTLSConfig 根据证书、私钥以及客户端CA证书的路径创建TLS配置
+// clientCAFile不为空时，按照clientAuth校验客户端证书，clientAuth为空则默认为verify_if_given
+func NewTLSConfig(certFile, keyFile, clientCAFile, clientAuth string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile == "" {
+		if clientAuth == TLSClientAuthVerifyIfGiven || clientAuth == TLSClientAuthRequire {
+			return nil, errors.New("tls client auth " + clientAuth + " needs a client CA file")
+		}
+		return config, nil
+	}
+
+	// 加载用于校验客户端证书的CA证书
+	caData, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(caData) {
+		return nil, errors.New("no valid certificate in client CA file " + clientCAFile)
+	}
+
+	switch clientAuth {
+	case TLSClientAuthNone:
+		config.ClientAuth = tls.NoClientCert
+	case TLSClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case TLSClientAuthVerifyIfGiven, "":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, errors.New("unsupported tls client auth: " + clientAuth)
+	}
+	return config, nil
+}
+
+// handleTLSConn 先完成TLS握手，再将连接交给handleConn处理，保证OnConnStart中就可以获取客户端的证书
+func (s *Server) handleTLSConn(conn *tls.Conn) {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		zlog.Info("TLS handshake error", zlog.RemoteAddr(conn.RemoteAddr()), zlog.Err(err))
+		s.Metrics.ConnRejected(RejectReasonTLSHandshake)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.handleConn(conn)
+}
+
+// peerCertificate 获取TLS连接中客户端提供的证书
+func peerCertificate(conn net.Conn) *x509.Certificate {
+	// WebSocket连接需要从底层的连接中获取
+	if ws, ok := conn.(*wsConn); ok {
+		conn = ws.conn.UnderlyingConn()
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	return certs[0]
+}
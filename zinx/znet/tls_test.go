@@ -0,0 +1,172 @@
+package znet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+	"zinx/ziface"
+)
+
+// newTestCert 生成一个测试用的证书，parent为nil时生成自签名的CA证书
+func newTestCert(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Generate key error:", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal("Create certificate error:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Parse certificate error:", err)
+	}
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// 开启TLS之后消息仍然使用DataPack封包，提供了证书的客户端可以在连接中获取到证书
+func TestServer_TLS(t *testing.T) {
+	ca, caKey, _ := newTestCert(t, "zinx ca", nil, nil)
+	_, _, serverCert := newTestCert(t, "zinx server", ca, caKey)
+	_, _, toolCert := newTestCert(t, "zinx tool", ca, caKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	s := NewServer(WithAddress("127.0.0.1", freePort(t))).(*Server)
+	s.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	s.AddRouter(1, &echoRouter{})
+
+	peers := make(chan string, 2)
+	s.SetOnConnStart(func(conn ziface.IConnection) {
+		if cert := conn.GetPeerCertificate(); cert != nil {
+			peers <- cert.Subject.CommonName
+		} else {
+			peers <- ""
+		}
+	})
+	s.Start()
+	defer s.Stop()
+
+	dial := func(certs []tls.Certificate) *tls.Conn {
+		conn := tls.Client(dialRetry(t, s.Addr().String()), &tls.Config{ServerName: "127.0.0.1", RootCAs: pool, Certificates: certs})
+		if err := conn.Handshake(); err != nil {
+			t.Fatal("Client handshake error:", err)
+		}
+		return conn
+	}
+
+	// 内部工具提供客户端证书
+	toolConn := dial([]tls.Certificate{toolCert})
+	defer toolConn.Close()
+	if name := <-peers; name != "zinx tool" {
+		t.Errorf("peer certificate = %q, want %q", name, "zinx tool")
+	}
+
+	// 普通客户端不提供证书
+	playerConn := dial(nil)
+	defer playerConn.Close()
+	if name := <-peers; name != "" {
+		t.Errorf("peer certificate = %q, want none", name)
+	}
+
+	dp := NewDataPack()
+	binaryMsg, _ := dp.Pack(NewMessage(1, []byte("secret")))
+	if _, err := playerConn.Write(binaryMsg); err != nil {
+		t.Fatal("Client write error:", err)
+	}
+	playerConn.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := dp.ReadMsg(playerConn)
+	if err != nil {
+		t.Fatal("Client read error:", err)
+	}
+	if string(msg.GetData()) != "secret" {
+		t.Errorf("reply Data = %s, want secret", msg.GetData())
+	}
+
+	// 客户端提供了未通过CA校验的证书，握手失败
+	_, _, strangerCert := newTestCert(t, "stranger", nil, nil)
+	if conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{strangerCert}}); err == nil {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("client with untrusted certificate should be rejected")
+		}
+		conn.Close()
+	}
+}
+
+// Server停止之后才完成TLS握手的连接被直接关闭，不会注册到ConnManager，也不会调用OnConnStart
+func TestServer_TLSHandshakeAfterStop(t *testing.T) {
+	ca, caKey, _ := newTestCert(t, "zinx ca", nil, nil)
+	_, _, serverCert := newTestCert(t, "zinx server", ca, caKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	started := make(chan struct{}, 1)
+	s := NewServer(
+		WithAddress("127.0.0.1", freePort(t)),
+		WithOnConnStart(func(conn ziface.IConnection) {
+			started <- struct{}{}
+		}),
+	).(*Server)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接已经被接收，正在等待客户端进行TLS握手
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	handled := make(chan struct{})
+	go func() {
+		s.handleTLSConn(tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{serverCert}}))
+		close(handled)
+	}()
+	s.Stop()
+
+	conn := tls.Client(clientConn, &tls.Config{ServerName: "127.0.0.1", RootCAs: pool})
+	if err := conn.Handshake(); err != nil {
+		t.Fatal("Client handshake error:", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection handshaken after Stop should be closed")
+	}
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("handshake after Stop was not handled")
+	}
+	if n := s.ConnManager.Len(); n != 0 {
+		t.Errorf("%d connections registered after Stop", n)
+	}
+	select {
+	case <-started:
+		t.Error("OnConnStart called after Stop")
+	default:
+	}
+}
@@ -0,0 +1,118 @@
+package znet
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"time"
+	"zinx/zlog"
+)
+
+// wsConn 将WebSocket连接适配为net.Conn，使Connection、IDataPack可以像处理TCP数据流一样处理WebSocket
+// 读：将连续的二进制帧的内容看作一个数据流；写：每次Write发送一个二进制帧
+type wsConn struct {
+	conn   *websocket.Conn // 当前的WebSocket连接
+	reader io.Reader       // 当前正在读取的帧
+}
+
+func newWsConn(conn *websocket.Conn) *wsConn {
+	return &wsConn{
+		conn: conn,
+	}
+}
+
+func (w *wsConn) Read(b []byte) (int, error) {
+	for {
+		// 当前帧没有读取完，继续读取
+		if w.reader != nil {
+			n, err := w.reader.Read(b)
+			if err == io.EOF {
+				w.reader = nil
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+
+		// 读取下一帧，只处理二进制帧
+		msgType, reader, err := w.conn.NextReader()
+		if err != nil {
+			return 0, err
+		}
+		if msgType != websocket.BinaryMessage {
+			return 0, errors.New("websocket only supports binary message")
+		}
+		w.reader = reader
+	}
+}
+
+func (w *wsConn) Write(b []byte) (int, error) {
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *wsConn) Close() error {
+	return w.conn.Close()
+}
+
+func (w *wsConn) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *wsConn) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+func (w *wsConn) SetDeadline(t time.Time) error {
+	if err := w.conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return w.conn.SetWriteDeadline(t)
+}
+
+func (w *wsConn) SetReadDeadline(t time.Time) error {
+	return w.conn.SetReadDeadline(t)
+}
+
+func (w *wsConn) SetWriteDeadline(t time.Time) error {
+	return w.conn.SetWriteDeadline(t)
+}
+
+// newWebSocketServer 创建WebSocket服务，升级之后的连接与TCP连接一样交给MsgHandler、ConnManager处理
+func (s *Server) newWebSocketServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.WsPath, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := s.WsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			zlog.Debug("WebSocket upgrade error", zlog.Any("remoteAddr", r.RemoteAddr), zlog.Err(err))
+			return
+		}
+		s.handleConn(newWsConn(conn))
+	})
+
+	return &http.Server{
+		Handler:   mux,
+		TLSConfig: s.TLSConfig,
+	}
+}
+
+// serveWebSocket 在已经监听的地址上提供WebSocket服务，直到服务被关闭
+func (s *Server) serveWebSocket(httpServer *http.Server, listener net.Listener) {
+	zlog.Info("Start Zinx WebSocket server", zlog.Any("server", s.Name), zlog.Any("addr", listener.Addr().String()+s.WsPath))
+	var err error
+	if s.TLSConfig != nil {
+		// 证书已经包含在TLSConfig中
+		err = httpServer.ServeTLS(listener, "", "")
+	} else {
+		err = httpServer.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		zlog.Error("WebSocket serve error", zlog.Err(err))
+	}
+}